@@ -7,5 +7,6 @@ cd ..
 
 go mod init course || true
 go get github.com/stretchr/testify/assert
+go get golang.org/x/crypto/bcrypt
 
 CGO_ENABLED=0 go test "./unit${UNITN}/exercises/e$1/..."
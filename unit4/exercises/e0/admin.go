@@ -0,0 +1,186 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// recentRequestsSize is how many of the last requests are shown on admin page.
+const recentRequestsSize = 50
+
+// adminFiles is admin page, it gets all the data from /api/stats.
+//
+//go:embed admin
+var adminFiles embed.FS
+
+type recentRequest struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Status    int           `json:"status"`
+	Duration  time.Duration `json:"duration"`
+	RequestID string        `json:"request_id"`
+}
+
+// requestStats counts handled requests and remembers the last of them.
+type requestStats struct {
+	started time.Time
+
+	mu       sync.Mutex
+	total    int64
+	inFlight int64
+	byStatus map[int]int64 // status class: 2 for 2xx and so on
+	recent   []recentRequest
+	next     int // position in recent to write the next request to, recent is ring buffer
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{
+		started:  time.Now(),
+		byStatus: map[int]int64{},
+		recent:   make([]recentRequest, 0, recentRequestsSize),
+	}
+}
+
+// Middleware counts requests passing through it.
+func (s *requestStats) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		s.mu.Lock()
+		s.inFlight++
+		s.mu.Unlock()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			s.add(recentRequest{
+				Time:      start,
+				Method:    r.Method,
+				URI:       r.RequestURI,
+				Status:    rec.status,
+				Duration:  time.Since(start),
+				RequestID: RequestIDFromContext(r.Context()),
+			})
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func (s *requestStats) add(req recentRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight--
+	s.total++
+	s.byStatus[req.Status/100]++
+
+	if len(s.recent) < cap(s.recent) {
+		s.recent = append(s.recent, req)
+	} else {
+		s.recent[s.next] = req
+	}
+	s.next = (s.next + 1) % cap(s.recent)
+}
+
+type statsSnapshot struct {
+	Uptime   time.Duration    `json:"uptime"`
+	Total    int64            `json:"total"`
+	InFlight int64            `json:"in_flight"`
+	ByStatus map[string]int64 `json:"by_status"`
+	Recent   []recentRequest  `json:"recent"` // the newest first
+	Routes   []routeInfo      `json:"routes"`
+	Runtime  runtimeStats     `json:"runtime"`
+}
+
+type runtimeStats struct {
+	GoVersion    string        `json:"go_version"`
+	GOMAXPROCS   int           `json:"gomaxprocs"`
+	Goroutines   int           `json:"goroutines"`
+	HeapAlloc    uint64        `json:"heap_alloc"`
+	HeapInuse    uint64        `json:"heap_inuse"`
+	Sys          uint64        `json:"sys"`
+	NumGC        uint32        `json:"num_gc"`
+	GCPauseTotal time.Duration `json:"gc_pause_total"`
+	LastGC       time.Time     `json:"last_gc"`
+}
+
+func (s *requestStats) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := statsSnapshot{
+		Uptime:   time.Since(s.started),
+		Total:    s.total,
+		InFlight: s.inFlight,
+		ByStatus: map[string]int64{},
+		Recent:   make([]recentRequest, 0, len(s.recent)),
+	}
+	for class, n := range s.byStatus {
+		snap.ByStatus[strconv.Itoa(class)+"xx"] = n
+	}
+	for i := range len(s.recent) {
+		// walk the ring backwards from the last written request
+		snap.Recent = append(snap.Recent, s.recent[(s.next-1-i+2*len(s.recent))%len(s.recent)])
+	}
+	return snap
+}
+
+func readRuntimeStats() runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := runtimeStats{
+		GoVersion:    runtime.Version(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		GCPauseTotal: time.Duration(m.PauseTotalNs),
+	}
+	if m.LastGC != 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	return stats
+}
+
+// newAdminHandler serves admin page, its data and pprof. It must be served on separate address
+// which is not reachable publicly: profiles and recent requests reveal a lot about the server.
+//
+// pprof handlers are registered explicitly: importing net/http/pprof also adds them to
+// http.DefaultServeMux, but public server doesn't use it.
+func newAdminHandler(stats *requestStats, routes func() []routeInfo) http.Handler {
+	mux := http.NewServeMux()
+
+	static, _ := fs.Sub(adminFiles, "admin")
+	mux.Handle("/", http.FileServerFS(static))
+
+	mux.HandleFunc("GET /api/stats", func(w http.ResponseWriter, r *http.Request) {
+		snap := stats.snapshot()
+		snap.Routes = routes()
+		snap.Runtime = readRuntimeStats()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(snap)
+	})
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
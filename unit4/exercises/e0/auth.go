@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	authRealm = "unit4"
+
+	defaultHMACWindow = 5 * time.Minute
+	maxHMACBody       = 10 << 20 // signed body is read into memory to check its digest
+)
+
+// routeAuth maps mux pattern to authentication schemes accepted by the route.
+// It satisfies flag.Value, so schemes can be set by repeating flag: -auth /echo=basic,bearer -auth /ungzip=hmac
+type routeAuth map[string][]string
+
+func (ra routeAuth) String() string {
+	parts := make([]string, 0, len(ra))
+	for pattern, schemes := range ra {
+		parts = append(parts, pattern+"="+strings.Join(schemes, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (ra routeAuth) Set(s string) error {
+	pattern, schemes, found := strings.Cut(s, "=")
+	if !found || pattern == "" || schemes == "" {
+		return fmt.Errorf("route auth %q must be in form path=scheme[,scheme...]", s)
+	}
+	ra[pattern] = splitList(schemes)
+	return nil
+}
+
+type userKey struct{}
+
+// UserFromContext returns name of authenticated user or key, or empty string for anonymous request.
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// authenticator checks credentials of one scheme in Authorization header.
+type authenticator interface {
+	// Scheme is name of the scheme in Authorization header, compared case-insensitively.
+	Scheme() string
+	// Authenticate checks credentials which follow the scheme in Authorization header
+	// and returns user name. Errors wrap ErrUnauthorized.
+	Authenticate(r *http.Request, credentials string) (string, error)
+	// Challenge is value of WWW-Authenticate header sent when authentication fails.
+	Challenge(err error) string
+}
+
+// authenticators are loaded from files given by flags, they are shared by all routes.
+type authenticators struct {
+	byName map[string]authenticator // by name used in -auth flag and routes file
+}
+
+func newAuthenticators(htpasswdFile, bearerFile, hmacFile string, replays *replayGuard) (*authenticators, error) {
+	a := &authenticators{byName: map[string]authenticator{}}
+
+	if htpasswdFile != "" {
+		basic, err := loadBasicAuth(htpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		a.byName["basic"] = basic
+	}
+	if bearerFile != "" {
+		bearer, err := loadBearerAuth(bearerFile)
+		if err != nil {
+			return nil, err
+		}
+		a.byName["bearer"] = bearer
+	}
+	if hmacFile != "" {
+		h, err := loadHMACAuth(hmacFile, replays)
+		if err != nil {
+			return nil, err
+		}
+		a.byName["hmac"] = h
+	}
+	return a, nil
+}
+
+// Middleware lets through requests authenticated with any of the schemes.
+func (a *authenticators) Middleware(schemes []string, next http.Handler) (http.Handler, error) {
+	auths := make([]authenticator, 0, len(schemes))
+	for _, name := range schemes {
+		auth, ok := a.byName[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("authentication scheme %q is unknown or its credentials file is not set", name)
+		}
+		auths = append(auths, auth)
+	}
+	return requireAuth(auths, next), nil
+}
+
+// requireAuth responds 401 Unauthorized with WWW-Authenticate header per scheme
+// to requests which are not authenticated by any of auths.
+func requireAuth(auths []authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := fmt.Errorf("%w: credentials are missing", ErrUnauthorized)
+
+		scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		var failed authenticator
+		for _, auth := range auths {
+			if !strings.EqualFold(scheme, auth.Scheme()) {
+				continue
+			}
+			user, authErr := auth.Authenticate(r, strings.TrimSpace(credentials))
+			if authErr == nil {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+				return
+			}
+			err, failed = authErr, auth
+		}
+
+		if !errors.Is(err, ErrUnauthorized) {
+			writeProblem(w, r, err) // request is broken, new credentials won't help
+			return
+		}
+		for _, auth := range auths {
+			if auth == failed {
+				w.Header().Add("WWW-Authenticate", auth.Challenge(err))
+			} else {
+				w.Header().Add("WWW-Authenticate", auth.Challenge(nil))
+			}
+		}
+		writeProblem(w, r, err)
+	})
+}
+
+// readCredentialsFile returns non-empty lines of the file which are not comments.
+func readCredentialsFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// basicAuth checks user and password against htpasswd file with bcrypt hashes,
+// like the one created by `htpasswd -B`.
+type basicAuth struct {
+	hashes map[string][]byte
+	dummy  []byte // compared for unknown users, so they can't be found by response time
+}
+
+func loadBasicAuth(filename string) (*basicAuth, error) {
+	lines, err := readCredentialsFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &basicAuth{hashes: map[string][]byte{}}
+	for i, line := range lines {
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("%s: entry %d must be in form user:hash", filename, i+1)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s: user %s: only bcrypt hashes are supported: %w", filename, user, err)
+		}
+		a.hashes[user] = []byte(hash)
+	}
+
+	a.dummy, err = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *basicAuth) Scheme() string {
+	return "Basic"
+}
+
+func (a *basicAuth) Authenticate(r *http.Request, credentials string) (string, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", fmt.Errorf("%w: malformed basic credentials", ErrUnauthorized)
+	}
+
+	hash, known := a.hashes[user]
+	if !known {
+		hash = a.dummy
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known {
+		return "", fmt.Errorf("%w: invalid user or password", ErrUnauthorized)
+	}
+	return user, nil
+}
+
+func (a *basicAuth) Challenge(err error) string {
+	return `Basic realm="` + authRealm + `", charset="UTF-8"`
+}
+
+// bearerAuth accepts static tokens from file of name:token lines.
+type bearerAuth struct {
+	names map[[sha256.Size]byte]string // by hash of token: map lookup doesn't leak token by timing
+}
+
+func loadBearerAuth(filename string) (*bearerAuth, error) {
+	lines, err := readCredentialsFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &bearerAuth{names: map[[sha256.Size]byte]string{}}
+	for i, line := range lines {
+		name, token, found := strings.Cut(line, ":")
+		if !found || name == "" || token == "" {
+			return nil, fmt.Errorf("%s: entry %d must be in form name:token", filename, i+1)
+		}
+		a.names[sha256.Sum256([]byte(token))] = name
+	}
+	return a, nil
+}
+
+func (a *bearerAuth) Scheme() string {
+	return "Bearer"
+}
+
+func (a *bearerAuth) Authenticate(r *http.Request, credentials string) (string, error) {
+	name, ok := a.names[sha256.Sum256([]byte(credentials))]
+	if !ok {
+		return "", fmt.Errorf("%w: invalid token", ErrUnauthorized)
+	}
+	return name, nil
+}
+
+func (a *bearerAuth) Challenge(err error) string {
+	if err != nil {
+		// error codes are defined in RFC 6750
+		return `Bearer realm="` + authRealm + `", error="invalid_token"`
+	}
+	return `Bearer realm="` + authRealm + `"`
+}
+
+// hmacAuth checks requests signed with shared secret keys from file of keyID:secret lines.
+// Client sends:
+//
+//	Authorization: HMAC-SHA256 keyId="client1", timestamp="1700000000", signature="base64"
+//
+// where signature is HMAC-SHA256 with the secret of the string
+//
+//	METHOD + "\n" + request URI + "\n" + timestamp + "\n" + hex SHA-256 of body
+//
+// Timestamp is Unix time in seconds, it must be within the window from server time.
+// Every signature is accepted only once, so captured request can't be replayed.
+type hmacAuth struct {
+	secrets map[string][]byte
+	replays *replayGuard
+}
+
+func loadHMACAuth(filename string, replays *replayGuard) (*hmacAuth, error) {
+	lines, err := readCredentialsFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &hmacAuth{secrets: map[string][]byte{}, replays: replays}
+	for i, line := range lines {
+		keyID, secret, found := strings.Cut(line, ":")
+		if !found || keyID == "" || secret == "" {
+			return nil, fmt.Errorf("%s: entry %d must be in form keyID:secret", filename, i+1)
+		}
+		a.secrets[keyID] = []byte(secret)
+	}
+	return a, nil
+}
+
+func (a *hmacAuth) Scheme() string {
+	return "HMAC-SHA256"
+}
+
+func (a *hmacAuth) Authenticate(r *http.Request, credentials string) (string, error) {
+	params := parseAuthParams(credentials)
+	keyID, timestamp := params["keyid"], params["timestamp"]
+
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil || keyID == "" || timestamp == "" || len(signature) == 0 {
+		return "", fmt.Errorf("%w: keyId, timestamp and signature are required", ErrUnauthorized)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: timestamp must be Unix time in seconds", ErrUnauthorized)
+	}
+	signedAt := time.Unix(seconds, 0)
+	if skew := time.Since(signedAt).Abs(); skew > a.replays.window {
+		return "", fmt.Errorf("%w: timestamp is outside of %s window", ErrUnauthorized, a.replays.window)
+	}
+
+	secret, ok := a.secrets[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+
+	// body is read to check its digest and then given to the handler from memory
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHMACBody+1))
+	if err != nil {
+		return "", fmt.Errorf("%w: can't read body: %v", ErrBadRequest, err)
+	}
+	if len(body) > maxHMACBody {
+		return "", fmt.Errorf("%w: signed body must not exceed %d bytes", ErrTooLarge, maxHMACBody)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := hmacSignature(secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal(signature, expected) {
+		return "", fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+
+	if !a.replays.firstUse(signature, signedAt) {
+		return "", fmt.Errorf("%w: signature is already used", ErrUnauthorized)
+	}
+	return keyID, nil
+}
+
+func (a *hmacAuth) Challenge(err error) string {
+	return `HMAC-SHA256 realm="` + authRealm + `"`
+}
+
+func hmacSignature(secret []byte, method, requestURI, timestamp string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+requestURI+"\n"+timestamp+"\n"+hex.EncodeToString(digest[:]))
+	return mac.Sum(nil)
+}
+
+// parseAuthParams parses comma-separated name="value" pairs, names are lowercased.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return params
+}
+
+// replayGuard remembers signatures used within the window. Older ones don't need to be remembered:
+// their timestamps are rejected anyway.
+type replayGuard struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // signature to its expiration
+	lastSweep time.Time
+}
+
+func newReplayGuard(window time.Duration) *replayGuard {
+	return &replayGuard{window: window, seen: map[string]time.Time{}}
+}
+
+// firstUse records signature and reports whether it's seen for the first time.
+func (g *replayGuard) firstUse(signature []byte, signedAt time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastSweep) >= time.Minute {
+		for sig, expires := range g.seen {
+			if now.After(expires) {
+				delete(g.seen, sig)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	key := string(signature)
+	if _, ok := g.seen[key]; ok {
+		return false
+	}
+	g.seen[key] = signedAt.Add(g.window)
+	return true
+}
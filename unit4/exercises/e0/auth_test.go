@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeTestFile(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "credentials")
+	if !assert.NoError(t, os.WriteFile(filename, []byte(content), 0o600)) {
+		t.FailNow()
+	}
+	return filename
+}
+
+func newTestAuthHandler(t *testing.T, schemes ...string) http.Handler {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	auths, err := newAuthenticators(
+		writeTestFile(t, "# users\nalice:"+string(hash)+"\n"),
+		writeTestFile(t, "ci:token-1\n"),
+		writeTestFile(t, "client1:hmac-secret\n"),
+		newReplayGuard(time.Minute),
+	)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	h, err := auths.Middleware(schemes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", UserFromContext(r.Context()), body)
+	}))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return h
+}
+
+func serveAuth(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func signTestRequest(r *http.Request, keyID, secret string, body string, signedAt time.Time) {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	signature := hmacSignature([]byte(secret), r.Method, r.URL.RequestURI(), timestamp, []byte(body))
+	r.Header.Set("Authorization", fmt.Sprintf(`HMAC-SHA256 keyId="%s", timestamp="%s", signature="%s"`,
+		keyID, timestamp, base64.StdEncoding.EncodeToString(signature)))
+}
+
+func TestUnknownAuthScheme(t *testing.T) {
+	auths, err := newAuthenticators("", "", "", newReplayGuard(time.Minute))
+	assert.NoError(t, err)
+	_, err = auths.Middleware([]string{"basic"}, http.NotFoundHandler())
+	assert.Error(t, err)
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := newTestAuthHandler(t, "basic", "bearer")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	w := serveAuth(h, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice ", w.Body.String())
+
+	for _, user := range []string{"alice", "bob"} {
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(user, "wrong")
+		w = serveAuth(h, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	}
+
+	// without credentials every accepted scheme is offered
+	w = serveAuth(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, []string{`Basic realm="unit4", charset="UTF-8"`, `Bearer realm="unit4"`}, w.Header().Values("WWW-Authenticate"))
+}
+
+func TestBearerAuth(t *testing.T) {
+	h := newTestAuthHandler(t, "bearer")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer token-1")
+	w := serveAuth(h, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ci ", w.Body.String())
+
+	r.Header.Set("Authorization", "Bearer token-2")
+	w = serveAuth(h, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="unit4", error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+
+	// basic credentials are not accepted by the route
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(h, r).Code)
+}
+
+func TestHMACAuth(t *testing.T) {
+	h := newTestAuthHandler(t, "hmac")
+	newSigned := func(body string, signedAt time.Time) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/echo?x=1", strings.NewReader(body))
+		signTestRequest(r, "client1", "hmac-secret", body, signedAt)
+		return r
+	}
+
+	r := newSigned("payload", time.Now())
+	w := serveAuth(h, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "client1 payload", w.Body.String()) // handler still gets the body
+
+	// the same request can't be replayed
+	replayed := httptest.NewRequest(http.MethodPost, "/echo?x=1", strings.NewReader("payload"))
+	replayed.Header = r.Header.Clone()
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(h, replayed).Code)
+
+	// body is changed after signing
+	r = newSigned("payload", time.Now().Add(time.Second))
+	r.Body = io.NopCloser(strings.NewReader("tampered"))
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(h, r).Code)
+
+	// timestamp is outside of the window
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(h, newSigned("payload", time.Now().Add(-2*time.Minute))).Code)
+
+	// unknown key
+	r = httptest.NewRequest(http.MethodPost, "/echo?x=1", strings.NewReader("payload"))
+	signTestRequest(r, "client2", "hmac-secret", "payload", time.Now())
+	w = serveAuth(h, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `HMAC-SHA256 realm="unit4"`, w.Header().Get("WWW-Authenticate"))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const defaultBlobMaxSize = 100 << 20
+
+// blobStore keeps uploaded blobs in directory under names equal to SHA-256 of their content:
+// <dir>/<first 2 hex digits>/<64 hex digits>. The same content is stored only once.
+type blobStore struct {
+	dir     string
+	maxSize int64
+}
+
+type blobInfo struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+func newBlobStore(dir string, maxSize int64) (*blobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &blobStore{dir: dir, maxSize: maxSize}, nil
+}
+
+func (s *blobStore) path(digest string) string {
+	return filepath.Join(s.dir, digest[:2], digest)
+}
+
+// Put streams request body to temporary file computing its hash on the way.
+// The file is renamed to its final name only when the whole body is received,
+// so partial uploads never appear in the store.
+func (s *blobStore) Put(w http.ResponseWriter, r *http.Request) error {
+	body := http.MaxBytesReader(w, r.Body, s.maxSize)
+
+	// temporary file is in the same directory as blobs: rename is atomic only within one filesystem
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // does nothing if the file is already renamed
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return fmt.Errorf("%w: upload is interrupted: %v", ErrBadRequest, err)
+	}
+
+	// data must be on disk before the file gets its final name
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	info := blobInfo{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	final := s.path(info.SHA256)
+
+	status := http.StatusCreated
+	if _, err := os.Stat(final); err == nil {
+		status = http.StatusOK // the same content is already stored
+	} else {
+		if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), final); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Location", "/blobs/"+info.SHA256)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(info)
+	return nil
+}
+
+// Get serves blob by its hash. Range and conditional requests are handled by http.ServeContent.
+func (s *blobStore) Get(w http.ResponseWriter, r *http.Request) error {
+	digest := r.PathValue("sha256")
+	if !isLowerHex(digest, 2*sha256.Size) {
+		return fmt.Errorf("%w: blob name must be SHA-256 in lowercase hex", ErrBadRequest)
+	}
+
+	f, err := os.Open(s.path(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// content of the blob can never change, so its hash is perfect strong ETag
+	w.Header().Set("ETag", `"`+digest+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+	return nil
+}
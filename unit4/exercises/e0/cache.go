@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCacheSize = 64 << 20
+
+// cacheableStatuses are statuses which responses are stored, others always go to the handler.
+var cacheableStatuses = []int{http.StatusOK, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone}
+
+// routeTTLs maps mux pattern to time its responses are cached for.
+// It satisfies flag.Value, so TTLs can be set by repeating flag: -cache /cycle/{n...}=30s -cache /blobs/{sha256}=1h
+type routeTTLs map[string]time.Duration
+
+func (rt routeTTLs) String() string {
+	parts := make([]string, 0, len(rt))
+	for pattern, ttl := range rt {
+		parts = append(parts, pattern+"="+ttl.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (rt routeTTLs) Set(s string) error {
+	pattern, value, found := strings.Cut(s, "=")
+	if !found || pattern == "" {
+		return fmt.Errorf("route cache %q must be in form path=ttl", s)
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return fmt.Errorf("route cache %q: ttl must be non-negative duration like 30s", s)
+	}
+	rt[pattern] = ttl
+	return nil
+}
+
+type cacheEntry struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// size approximates memory used by the entry, it's what cache size limit is applied to.
+func (e *cacheEntry) size() int64 {
+	size := len(e.key) + len(e.body)
+	for name, values := range e.header {
+		size += len(name)
+		for _, v := range values {
+			size += len(v)
+		}
+	}
+	return int64(size)
+}
+
+// cacheCall is response being computed, concurrent identical requests wait for it instead of calling handler.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry // nil if response can't be cached
+}
+
+// responseCache keeps responses of GET and HEAD requests in memory. Entries are keyed by method,
+// path, query and values of vary request headers, the least recently used are evicted when
+// total size exceeds maxBytes.
+type responseCache struct {
+	maxBytes int64
+	vary     []string // canonical names of request headers responses can depend on
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cacheEntry, the most recently used at front
+	entries map[string]*list.Element
+	calls   map[string]*cacheCall
+}
+
+func newResponseCache(maxBytes int64, vary []string) *responseCache {
+	canonical := make([]string, 0, len(vary))
+	for _, name := range vary {
+		canonical = append(canonical, http.CanonicalHeaderKey(name))
+	}
+	return &responseCache{
+		maxBytes: maxBytes,
+		vary:     canonical,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		calls:    map[string]*cacheCall{},
+	}
+}
+
+func (c *responseCache) key(r *http.Request) string {
+	var b strings.Builder
+	// Encode sorts parameters, so their order in the URL doesn't matter
+	b.WriteString(r.Method + " " + r.URL.Path + "?" + r.URL.Query().Encode())
+	for _, name := range c.vary {
+		b.WriteString("\n" + name + ": " + strings.Join(r.Header.Values(name), ", "))
+	}
+	return b.String()
+}
+
+// lookup returns fresh entry or nil. Must be called with mu locked.
+func (c *responseCache) lookup(key string, now time.Time) *cacheEntry {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if !now.Before(e.expires) {
+		c.remove(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+// add stores entry evicting the least recently used ones. Must be called with mu locked.
+func (c *responseCache) add(e *cacheEntry) {
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size()
+
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+}
+
+// Purge removes all entries, for example when routes are reloaded and handlers can respond differently.
+func (c *responseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.entries)
+	c.size = 0
+}
+
+// Middleware caches responses of next for ttl.
+func (c *responseCache) Middleware(ttl time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := c.key(r)
+		if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+			c.serveWithCredentials(w, r, key, ttl, next)
+			return
+		}
+
+		c.mu.Lock()
+		if e := c.lookup(key, time.Now()); e != nil {
+			c.mu.Unlock()
+			c.serve(w, r, e, "HIT")
+			return
+		}
+
+		if call, ok := c.calls[key]; ok {
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return // client has gone while waiting
+			}
+			if call.entry != nil {
+				c.serve(w, r, call.entry, "HIT")
+				return
+			}
+			// response to the first request can't be shared, so every request gets its own
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// this request is the first one, others with the same key will wait for its response
+		call := &cacheCall{done: make(chan struct{})}
+		c.calls[key] = call
+		c.mu.Unlock()
+
+		rec := &cacheRecorder{header: http.Header{}}
+		func() {
+			// waiting requests must be released even if handler panics
+			defer func() {
+				c.mu.Lock()
+				delete(c.calls, key)
+				if call.entry != nil {
+					c.add(call.entry)
+				}
+				c.mu.Unlock()
+				close(call.done)
+			}()
+
+			next.ServeHTTP(rec, r)
+			if r.Context().Err() == nil {
+				// response of canceled request can be cut short, waiting requests call handler themselves
+				call.entry = c.entryOf(key, rec, ttl)
+			}
+		}()
+
+		if call.entry != nil {
+			c.serve(w, r, call.entry, "MISS")
+			return
+		}
+		rec.writeTo(w)
+	})
+}
+
+// serveWithCredentials calls next for request with Authorization or Cookie, its response may be
+// meant for that client only. As RFC 9111 §3.5 requires, it's stored only if Cache-Control
+// explicitly allows shared caches to, and such request is never answered from the cache.
+func (c *responseCache) serveWithCredentials(w http.ResponseWriter, r *http.Request, key string, ttl time.Duration, next http.Handler) {
+	rec := &cacheRecorder{header: http.Header{}}
+	next.ServeHTTP(rec, r)
+	if r.Context().Err() == nil && sharedExplicitly(rec.header) {
+		if e := c.entryOf(key, rec, ttl); e != nil {
+			c.mu.Lock()
+			c.add(e)
+			c.mu.Unlock()
+			c.serve(w, r, e, "MISS")
+			return
+		}
+	}
+	rec.writeTo(w)
+}
+
+// sharedExplicitly tells if Cache-Control has public or s-maxage directive.
+func sharedExplicitly(h http.Header) bool {
+	for _, directive := range splitList(strings.Join(h.Values("Cache-Control"), ",")) {
+		name, _, _ := strings.Cut(strings.ToLower(directive), "=")
+		if name == "public" || name == "s-maxage" {
+			return true
+		}
+	}
+	return false
+}
+
+// entryOf makes cache entry from recorded response, or returns nil if it must not be cached.
+func (c *responseCache) entryOf(key string, rec *cacheRecorder, ttl time.Duration) *cacheEntry {
+	status := rec.status
+	if status == 0 {
+		return nil // handler wrote nothing, there is no response to store
+	}
+	if !slices.Contains(cacheableStatuses, status) {
+		return nil
+	}
+
+	cacheControl := strings.ToLower(strings.Join(rec.header.Values("Cache-Control"), ","))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return nil
+	}
+	if rec.header.Get("Set-Cookie") != "" {
+		return nil // cookies are for one client only
+	}
+	for _, name := range splitList(strings.Join(rec.header.Values("Vary"), ",")) {
+		// response depends on request header which is not in the key
+		if name == "*" || !slices.Contains(c.vary, http.CanonicalHeaderKey(name)) {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	e := &cacheEntry{
+		key:     key,
+		status:  status,
+		header:  rec.header.Clone(),
+		body:    bytes.Clone(rec.body.Bytes()),
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	if e.header.Get("ETag") == "" {
+		sum := sha256.Sum256(e.body)
+		e.header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	// downstream caches must know what the response depends on as well
+	for _, name := range c.vary {
+		if !headerContainsToken(e.header, "Vary", name) {
+			e.header.Add("Vary", name)
+		}
+	}
+
+	if e.size() > c.maxBytes {
+		return nil
+	}
+	return e
+}
+
+// serve writes cached response. Conditional request with matching ETag gets 304 Not Modified.
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, e *cacheEntry, cacheStatus string) {
+	h := w.Header()
+	copyHeader(h, e.header)
+	h.Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+	h.Set("X-Cache", cacheStatus)
+
+	if e.status == http.StatusOK && etagMatches(r.Header.Get("If-None-Match"), e.header.Get("ETag")) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if len(e.body) > 0 || h.Get("Content-Length") == "" {
+		// response to HEAD has no body, but it keeps Content-Length of GET response set by handler
+		h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// copyHeader copies header of stored or recorded response to dst. Values are cloned, entry is shared
+// and nobody must change its header. Vary is merged instead of replaced: Vary: Origin is already
+// in dst if router has added CORS headers.
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		if name != "Vary" {
+			dst[name] = slices.Clone(values)
+			continue
+		}
+		for _, token := range splitList(strings.Join(values, ",")) {
+			if !headerContainsToken(dst, "Vary", token) {
+				dst.Add("Vary", token)
+			}
+		}
+	}
+}
+
+// etagMatches checks If-None-Match header using weak comparison as RFC 9110 requires for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheRecorder keeps whole response in memory, so it can be stored and sent to many clients.
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// writeTo sends recorded response as is, it's used when response can't be cached.
+func (rec *cacheRecorder) writeTo(w http.ResponseWriter) {
+	copyHeader(w.Header(), rec.header)
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+	}
+	w.Write(rec.body.Bytes())
+}
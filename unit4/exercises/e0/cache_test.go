@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHandler responds with number of its calls, so cached responses can be told from fresh ones.
+func countingHandler(calls *atomic.Int32, delay time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		time.Sleep(delay)
+		fmt.Fprintf(w, "call %d", n)
+	})
+}
+
+func serveCached(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCacheHitAndMiss(t *testing.T) {
+	var calls atomic.Int32
+	h := newResponseCache(1<<20, []string{"accept"}).Middleware(time.Minute, countingHandler(&calls, 0))
+
+	first := serveCached(h, http.MethodGet, "/cycle/5?a=1&b=2", nil)
+	assert.Equal(t, "MISS", first.Header().Get("X-Cache"))
+	assert.Equal(t, "call 1", first.Body.String())
+	assert.NotEmpty(t, first.Header().Get("ETag"))
+	assert.Equal(t, "0", first.Header().Get("Age"))
+	assert.Equal(t, "Accept", first.Header().Get("Vary"))
+
+	// order of query parameters doesn't matter
+	second := serveCached(h, http.MethodGet, "/cycle/5?b=2&a=1", nil)
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, "call 1", second.Body.String())
+	assert.Equal(t, first.Header().Get("ETag"), second.Header().Get("ETag"))
+
+	// different query, vary header and method are different entries
+	assert.Equal(t, "call 2", serveCached(h, http.MethodGet, "/cycle/5?a=2", nil).Body.String())
+	assert.Equal(t, "call 3", serveCached(h, http.MethodGet, "/cycle/5?a=1&b=2", http.Header{"Accept": {"text/plain"}}).Body.String())
+	assert.Equal(t, "call 4", serveCached(h, http.MethodPost, "/cycle/5?a=1&b=2", nil).Body.String())
+	assert.Equal(t, "call 5", serveCached(h, http.MethodPost, "/cycle/5?a=1&b=2", nil).Body.String())
+}
+
+func TestCacheConditionalRequest(t *testing.T) {
+	var calls atomic.Int32
+	h := newResponseCache(1<<20, nil).Middleware(time.Minute, countingHandler(&calls, 0))
+
+	etag := serveCached(h, http.MethodGet, "/x", nil).Header().Get("ETag")
+
+	w := serveCached(h, http.MethodGet, "/x", http.Header{"If-None-Match": {`"other", W/` + etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = serveCached(h, http.MethodGet, "/x", http.Header{"If-None-Match": {`"other"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestCacheExpiresAndSkipsUncacheable(t *testing.T) {
+	var calls atomic.Int32
+	c := newResponseCache(1<<20, nil)
+	h := c.Middleware(20*time.Millisecond, countingHandler(&calls, 0))
+
+	serveCached(h, http.MethodGet, "/x", nil)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, "call 2", serveCached(h, http.MethodGet, "/x", nil).Body.String())
+
+	noStore := c.Middleware(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, calls.Add(1))
+	}))
+	serveCached(noStore, http.MethodGet, "/no-store", nil)
+	w := serveCached(noStore, http.MethodGet, "/no-store", nil)
+	assert.Equal(t, "4", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Cache"))
+
+	failing := c.Middleware(time.Minute, errorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		calls.Add(1)
+		return ErrBadRequest
+	}))
+	serveCached(failing, http.MethodGet, "/failing", nil)
+	w = serveCached(failing, http.MethodGet, "/failing", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, int32(6), calls.Load())
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	var calls atomic.Int32
+	c := newResponseCache(1<<20, nil)
+	h := c.Middleware(time.Minute, countingHandler(&calls, 0))
+
+	serveCached(h, http.MethodGet, "/a", nil)
+	e := c.entries[c.key(httptest.NewRequest(http.MethodGet, "/a", nil))].Value.(*cacheEntry)
+	c.maxBytes = 2 * e.size() // room for two entries of the same size
+
+	serveCached(h, http.MethodGet, "/b", nil)
+	serveCached(h, http.MethodGet, "/a", nil) // /a is used more recently than /b now
+	serveCached(h, http.MethodGet, "/c", nil)
+
+	assert.Equal(t, "HIT", serveCached(h, http.MethodGet, "/a", nil).Header().Get("X-Cache"))
+	assert.Equal(t, "MISS", serveCached(h, http.MethodGet, "/b", nil).Header().Get("X-Cache"))
+	assert.LessOrEqual(t, c.size, c.maxBytes)
+}
+
+func TestCacheCollapsesConcurrentRequests(t *testing.T) {
+	var calls atomic.Int32
+	h := newResponseCache(1<<20, nil).Middleware(time.Minute, countingHandler(&calls, 50*time.Millisecond))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i] = serveCached(h, http.MethodGet, "/cycle/100", nil).Body.String()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, strings.Repeat("call 1", len(bodies)), strings.Join(bodies, ""))
+}
+
+func TestCacheSkipsCanceledRequests(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	h := newResponseCache(1<<20, nil).Middleware(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			// like /cycle, the handler returns without response when client has gone
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprintf(w, "call %d", n)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan struct{})
+	go func() {
+		defer close(canceled)
+		r := httptest.NewRequest(http.MethodGet, "/cycle/100", nil).WithContext(ctx)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	<-started
+
+	waiting := make(chan *httptest.ResponseRecorder)
+	go func() {
+		waiting <- serveCached(h, http.MethodGet, "/cycle/100", nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-canceled
+
+	w := <-waiting
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "call 2", w.Body.String())
+
+	next := serveCached(h, http.MethodGet, "/cycle/100", nil)
+	assert.NotEmpty(t, next.Body.String())
+	assert.NotEqual(t, "call 1", next.Body.String())
+}
+
+func TestCacheSkipsRequestsWithCredentials(t *testing.T) {
+	var calls atomic.Int32
+	c := newResponseCache(1<<20, nil)
+	h := c.Middleware(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("public") {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		fmt.Fprintf(w, "call %d %s%s", calls.Add(1), r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+	}))
+
+	w := serveCached(h, http.MethodGet, "/anything", http.Header{"Authorization": {"Bearer token-1"}})
+	assert.Equal(t, "call 1 Bearer token-1", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	assert.Equal(t, "call 2 ", serveCached(h, http.MethodGet, "/anything", nil).Body.String())
+	assert.Equal(t, "call 3 session=1", serveCached(h, http.MethodGet, "/anything", http.Header{"Cookie": {"session=1"}}).Body.String())
+	assert.Equal(t, "call 4 session=2", serveCached(h, http.MethodGet, "/anything", http.Header{"Cookie": {"session=2"}}).Body.String())
+
+	// response which is explicitly public can be shared
+	w = serveCached(h, http.MethodGet, "/anything?public", http.Header{"Authorization": {"Bearer token-1"}})
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	w = serveCached(h, http.MethodGet, "/anything?public", nil)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "call 5 Bearer token-1", w.Body.String())
+}
+
+func TestCacheKeepsResponsesOfUsersApart(t *testing.T) {
+	var calls atomic.Int32
+	c := newResponseCache(1<<20, nil)
+	h := c.Middleware(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "call %d %s", calls.Add(1), UserFromContext(r.Context()))
+	}))
+
+	// the cache is inside authentication as in newRoutes
+	auths, err := newAuthenticators("", writeTestFile(t, "alice:token-1\nbob:token-2\n"), "", newReplayGuard(time.Minute))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	authed, err := auths.Middleware([]string{"bearer"}, h)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	serveAs := func(token string) *httptest.ResponseRecorder {
+		return serveCached(authed, http.MethodGet, "/private", http.Header{"Authorization": {"Bearer " + token}})
+	}
+
+	assert.Equal(t, "call 1 alice", serveAs("token-1").Body.String())
+	assert.Equal(t, "call 2 bob", serveAs("token-2").Body.String())
+	w := serveAs("token-1")
+	assert.Equal(t, "call 3 alice", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	assert.Empty(t, c.entries)
+
+	assert.Equal(t, http.StatusUnauthorized, serveCached(authed, http.MethodGet, "/private", nil).Code)
+}
+
+func TestCacheMergesVaryAndKeepsHeadLength(t *testing.T) {
+	c := newResponseCache(1<<20, []string{"Accept"})
+	h := c.Middleware(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept")
+		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader("hello"))
+	}))
+	// like router with CORS, which adds Vary: Origin before the cache
+	withCORS := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		h.ServeHTTP(w, r)
+	})
+
+	for _, cacheStatus := range []string{"MISS", "HIT"} {
+		w := serveCached(withCORS, http.MethodGet, "/hello", nil)
+		assert.Equal(t, cacheStatus, w.Header().Get("X-Cache"))
+		assert.Equal(t, []string{"Origin", "Accept"}, w.Header().Values("Vary"))
+
+		w = serveCached(withCORS, http.MethodHead, "/hello", nil)
+		assert.Equal(t, cacheStatus, w.Header().Get("X-Cache"))
+		assert.Equal(t, "5", w.Header().Get("Content-Length"))
+		assert.Empty(t, w.Body.String())
+	}
+
+	// response which isn't cached keeps Vary: Origin too
+	uncached := c.Middleware(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Cookie")
+		fmt.Fprint(w, "hello")
+	}))
+	w := serveCached(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		uncached.ServeHTTP(w, r)
+	}), http.MethodGet, "/uncached", nil)
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	assert.Equal(t, []string{"Origin", "Cookie"}, w.Header().Values("Vary"))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultCycleSize    = 5
+	maxCycleSize        = 100
+	defaultCycleTimeout = 11 * time.Second
+)
+
+// process and cycle are the same as in unit6.
+func process(iteration int) string {
+	time.Sleep(2 * time.Second)
+	return fmt.Sprintf("iteration %d is processed", iteration)
+}
+
+func cycle(ctx context.Context, size int) []string {
+	lines := []string{}
+	for i := 0; i < size; i++ {
+		select {
+		case <-ctx.Done():
+			return lines
+		default:
+			lines = append(lines, process(i))
+		}
+	}
+	return lines
+}
+
+// newCycleHandler calls cycle() with N iterations for /cycle/{n...} path, N is 5 by default.
+// Time of processing is limited by timeout query parameter in seconds.
+func newCycleHandler() errorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		size := defaultCycleSize
+		if s := r.PathValue("n"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 || n > maxCycleSize {
+				return fmt.Errorf("%w: number of iterations must be from 0 to %d", ErrBadRequest, maxCycleSize)
+			}
+			size = n
+		}
+
+		timeout := defaultCycleTimeout
+		if s := r.URL.Query().Get("timeout"); s != "" {
+			seconds, err := strconv.Atoi(s)
+			if err != nil || seconds <= 0 {
+				return fmt.Errorf("%w: timeout must be positive number of seconds", ErrBadRequest)
+			}
+			timeout = time.Duration(seconds) * time.Second
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		lines := cycle(ctx, size)
+
+		switch ctx.Err() {
+		case context.Canceled:
+			log.Printf("incoming request %s was canceled by client %s", r.RequestURI, logPrefix(ctx))
+			return nil // nobody will read the response
+		case context.DeadlineExceeded:
+			log.Printf("incoming request %s reached timeout %s", r.RequestURI, logPrefix(ctx))
+			w.Header().Set("Cache-Control", "no-store") // result is partial, next request can get full one
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultEventsInterval = time.Second
+	minEventsInterval     = 100 * time.Millisecond
+	// maxEventsDuration ends every stream after a while, so forgotten clients don't hold it forever.
+	// Browsers reconnect by themselves and continue from Last-Event-ID.
+	maxEventsDuration = 30 * time.Minute
+)
+
+type tickEvent struct {
+	N    int       `json:"n"`
+	Time time.Time `json:"time"`
+}
+
+// newEventsHandler streams tick events to the client with Server-Sent Events:
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+//
+// Query parameters: interval between events (Go duration, 1s by default)
+// and count of events (infinite by default, but stream is closed after maxEventsDuration).
+// Reconnected client continues from the event after Last-Event-ID.
+func newEventsHandler() errorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		interval := defaultEventsInterval
+		if s := r.URL.Query().Get("interval"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < minEventsInterval {
+				return fmt.Errorf("%w: interval must be duration not less than %s", ErrBadRequest, minEventsInterval)
+			}
+			interval = d
+		}
+
+		count := 0 // infinite
+		if s := r.URL.Query().Get("count"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return fmt.Errorf("%w: count must be non-negative integer", ErrBadRequest)
+			}
+			count = n
+		}
+
+		next := 0
+		if s := r.Header.Get("Last-Event-ID"); s != "" {
+			if last, err := strconv.Atoi(s); err == nil && last >= 0 {
+				next = last + 1
+			}
+		}
+
+		if !startStream(r.Context()) {
+			tooManyRequests(w, r, time.Second)
+			return nil
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // ask nginx and similar proxies not to buffer the stream
+
+		// ResponseController finds Flush of the original writer even if it's wrapped by middlewares
+		rc := http.NewResponseController(w)
+		if err := rc.Flush(); err != nil {
+			if errors.Is(err, http.ErrNotSupported) {
+				return fmt.Errorf("streaming is not supported: %w", err)
+			}
+			return nil // client has gone
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		timeout := time.NewTimer(maxEventsDuration)
+		defer timeout.Stop()
+
+		for sent := 0; count == 0 || sent < count; sent++ {
+			select {
+			case <-r.Context().Done():
+				// context is canceled once client disconnects, stop producing events
+				log.Printf("event stream %s is closed by client %s", r.RequestURI, logPrefix(r.Context()))
+				return nil
+			case <-timeout.C:
+				log.Printf("event stream %s is closed after %s %s", r.RequestURI, maxEventsDuration, logPrefix(r.Context()))
+				return nil
+			case now := <-ticker.C:
+				data, _ := json.Marshal(tickEvent{N: next, Time: now})
+				fmt.Fprintf(w, "id: %d\nevent: tick\ndata: %s\n\n", next, data)
+				if err := rc.Flush(); err != nil {
+					return nil
+				}
+				next++
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func newRootHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := bytes.NewBuffer(nil)
+
+		response.WriteString(r.Method) // GET, POST and other HTTP methods
+		response.WriteString(" ")
+		response.WriteString("URI: ")
+		response.WriteString(r.RequestURI) // Request URI
+		response.WriteString(" ")
+		response.WriteString("handling with / handler")
+		response.WriteByte('\n')
+
+		response.WriteTo(w) // or io.Copy(w, response)
+		// Note that w of type http.ResponseWriter implements io.Writer. it can be used with any code supports io.Writer
+	}
+}
+
+// newEchoHandler sends request body back to the client.
+func newEchoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}
+}
+
+// newUngzipHandler decompresses gzipped request body and sends it back.
+// Malformed input is answered with 400 Bad Request.
+func newUngzipHandler() errorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return fmt.Errorf("%w: body is not gzipped: %v", ErrBadRequest, err)
+		}
+		defer zr.Close()
+
+		// the whole output is buffered: the error can happen in the middle of the stream
+		// and status code can't be changed once first bytes are written to w
+		response := bytes.NewBuffer(nil)
+		if _, err := io.Copy(response, zr); err != nil {
+			return fmt.Errorf("%w: malformed gzip stream: %v", ErrBadRequest, err)
+		}
+
+		response.WriteTo(w)
+		return nil
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxInspectBody limits size of the body read by inspect handler
+	maxInspectBody = 32 << 20
+	// maxFormValue limits size of single non-file multipart field kept in memory
+	maxFormValue = 64 << 10
+)
+
+// requestInfo describes request as it was seen by the server.
+type requestInfo struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	RemoteAddr string              `json:"remote_addr"`
+	Headers    map[string][]string `json:"headers"`
+	Query      map[string][]string `json:"query"`
+	Form       map[string][]string `json:"form,omitempty"`
+	Files      []fileInfo          `json:"files,omitempty"`
+	TLS        *tlsInfo            `json:"tls,omitempty"`
+	BodyLength int64               `json:"body_length"`
+}
+
+type fileInfo struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+type tlsInfo struct {
+	Version            string   `json:"version"`
+	CipherSuite        string   `json:"cipher_suite"`
+	ServerName         string   `json:"server_name,omitempty"`
+	NegotiatedProtocol string   `json:"negotiated_protocol,omitempty"`
+	PeerCertificates   []string `json:"peer_certificates,omitempty"`
+}
+
+// countingReader counts bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newInspectHandler describes incoming request in JSON or text depending on Accept header,
+// like httpbin's /anything does. It's handy to debug clients against our own server.
+func newInspectHandler() errorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		body := &countingReader{r: http.MaxBytesReader(w, r.Body, maxInspectBody)}
+
+		info := &requestInfo{
+			Method:     r.Method,
+			URL:        r.URL.String(),
+			Proto:      r.Proto,
+			Host:       r.Host,
+			RemoteAddr: r.RemoteAddr,
+			Headers:    r.Header,
+			Query:      r.URL.Query(),
+		}
+		if r.TLS != nil {
+			info.TLS = newTLSInfo(r.TLS)
+		}
+
+		if err := info.readBody(r.Header.Get("Content-Type"), body); err != nil {
+			return err
+		}
+		info.BodyLength = body.n
+
+		response := bytes.NewBuffer(nil)
+		if prefersText(r) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			info.writeText(response)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(response)
+			enc.SetIndent("", "  ")
+			enc.Encode(info)
+		}
+		response.WriteTo(w)
+		return nil
+	}
+}
+
+// readBody parses form fields and files. Body of any other type is just read to count its length.
+func (info *requestInfo) readBody(contentType string, body io.Reader) error {
+	mediatype, params, _ := mime.ParseMediaType(contentType)
+
+	switch mediatype {
+	case "application/x-www-form-urlencoded":
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			return fmt.Errorf("%w: malformed form: %v", ErrBadRequest, err)
+		}
+		info.Form = form
+		return nil
+	case "multipart/form-data":
+		return info.readMultipart(body, params["boundary"])
+	default:
+		_, err := io.Copy(io.Discard, body)
+		return err
+	}
+}
+
+// readMultipart streams parts of the body, so large files are never kept in memory: only their hashes.
+func (info *requestInfo) readMultipart(body io.Reader, boundary string) error {
+	if boundary == "" {
+		return fmt.Errorf("%w: malformed multipart form: no boundary", ErrBadRequest)
+	}
+
+	mr := multipart.NewReader(body, boundary)
+	info.Form = map[string][]string{}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: malformed multipart form: %w", ErrBadRequest, err)
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValue))
+			if err != nil {
+				return fmt.Errorf("%w: malformed multipart form: %w", ErrBadRequest, err)
+			}
+			info.Form[part.FormName()] = append(info.Form[part.FormName()], string(value))
+			continue
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, part)
+		if err != nil {
+			return fmt.Errorf("%w: malformed multipart form: %w", ErrBadRequest, err)
+		}
+		info.Files = append(info.Files, fileInfo{
+			Field:       part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        size,
+			SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+
+	// drain the rest, for example epilogue after the last boundary, so body length is complete
+	_, err := io.Copy(io.Discard, body)
+	return err
+}
+
+func newTLSInfo(state *tls.ConnectionState) *tlsInfo {
+	info := &tlsInfo{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+	}
+	for _, cert := range state.PeerCertificates {
+		info.PeerCertificates = append(info.PeerCertificates, cert.Subject.String())
+	}
+	return info
+}
+
+func (info *requestInfo) writeText(w io.Writer) {
+	fmt.Fprintf(w, "%s %s %s\n", info.Method, info.URL, info.Proto)
+	fmt.Fprintf(w, "Host: %s\n", info.Host)
+	fmt.Fprintf(w, "Remote address: %s\n", info.RemoteAddr)
+	fmt.Fprintf(w, "Body length: %d\n", info.BodyLength)
+
+	writeValues(w, "Headers", info.Headers)
+	writeValues(w, "Query", info.Query)
+	writeValues(w, "Form", info.Form)
+
+	if len(info.Files) > 0 {
+		fmt.Fprintln(w, "\nFiles:")
+		for _, f := range info.Files {
+			fmt.Fprintf(w, "  %s: %s (%s, %d bytes, sha256 %s)\n", f.Field, f.Filename, f.ContentType, f.Size, f.SHA256)
+		}
+	}
+
+	if info.TLS != nil {
+		fmt.Fprintln(w, "\nTLS:")
+		fmt.Fprintf(w, "  Version: %s\n", info.TLS.Version)
+		fmt.Fprintf(w, "  Cipher suite: %s\n", info.TLS.CipherSuite)
+		if info.TLS.ServerName != "" {
+			fmt.Fprintf(w, "  Server name: %s\n", info.TLS.ServerName)
+		}
+		if info.TLS.NegotiatedProtocol != "" {
+			fmt.Fprintf(w, "  Protocol: %s\n", info.TLS.NegotiatedProtocol)
+		}
+		for _, subject := range info.TLS.PeerCertificates {
+			fmt.Fprintf(w, "  Peer certificate: %s\n", subject)
+		}
+	}
+}
+
+// writeValues prints values sorted by name, so output is stable.
+func writeValues(w io.Writer, title string, values map[string][]string) {
+	if len(values) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, name := range names {
+		for _, v := range values[name] {
+			fmt.Fprintf(w, "  %s: %s\n", name, v)
+		}
+	}
+}
+
+// prefersText reports whether client asked for text/plain with higher priority than application/json.
+// JSON is the default.
+func prefersText(r *http.Request) bool {
+	textQ, jsonQ := -1.0, -1.0
+
+	for _, v := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(v, ",") {
+			mediatype, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if parsed, err := strconv.ParseFloat(s, 64); err == nil {
+					q = parsed
+				}
+			}
+			switch mediatype {
+			case "text/plain":
+				textQ = max(textQ, q)
+			case "application/json":
+				jsonQ = max(jsonQ, q)
+			}
+		}
+	}
+	return textQ > 0 && textQ > jsonQ
+}
@@ -1,32 +1,354 @@
 package main
 
 import (
-	"bytes"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// serverOptions are set by command line flags. Everything needed to build router is here,
+// so router can be rebuilt from scratch when routes file is changed.
+type serverOptions struct {
+	staticDir       string
+	staticPrefix    string
+	staticListing   bool
+	proxyConfigFile string
+	routesFile      string
+	limits          routeLimits
+	ips             *clientIPResolver
+	cors            *corsPolicy
+	wsMaxMessage    int64
+	wsIdleTimeout   time.Duration
+	blobsDir        string
+	blobsMaxSize    int64
+	cache           *responseCache
+	cacheTTLs       routeTTLs
+	htpasswdFile    string
+	bearerFile      string
+	hmacKeysFile    string
+	replays         *replayGuard
+	auth            routeAuth
+	resources       *routeResources // of the current router
+}
+
+// routeSettings are per-route middleware settings from routes file.
+// Unset ones are taken from flags for the path.
+type routeSettings struct {
+	limit    *rateLimit
+	cacheTTL *time.Duration
+	auth     []string
+}
+
+type builtinRoute struct {
+	methods []string
+	path    string
+	handler http.Handler
+}
+
+// anyMethod is methods list of route which handles all methods
+var anyMethod = []string{""}
+
+// newRoutes creates router with built-in handlers and handlers from configuration files.
+// Rate limiters and directories of the previous router are reused.
+func newRoutes(opts *serverOptions) (_ *router, err error) {
+	res := newRouteResources(opts.resources)
+	defer func() {
+		if err != nil {
+			res.discard()
+			return
+		}
+		res.commit()
+		opts.resources = res
+	}()
+
+	rt := newRouter(opts.cors) // Creating new router to manage handlers for different paths and methods.
+
+	auths, err := newAuthenticators(opts.htpasswdFile, opts.bearerFile, opts.hmacKeysFile, opts.replays)
+	if err != nil {
+		return nil, err
+	}
+
+	// handle registers handler in router wrapping it with response cache if there is TTL for the path,
+	// with authentication if it requires any and with rate limiter if there is a limit for the path.
+	// Rate limiter is the outermost, so it slows down password guessing as well.
+	handle := func(methods []string, path string, h http.Handler, s routeSettings) error {
+		ttl := s.cacheTTL
+		if ttl == nil {
+			if t, ok := opts.cacheTTLs[path]; ok {
+				ttl = &t
+			}
+		}
+		if ttl != nil && *ttl > 0 && opts.cache != nil {
+			h = opts.cache.Middleware(*ttl, h)
+		}
+
+		schemes := s.auth
+		if schemes == nil {
+			schemes = opts.auth[path]
+		}
+		if len(schemes) > 0 {
+			var err error
+			if h, err = auths.Middleware(schemes, h); err != nil {
+				return fmt.Errorf("route %s: %w", path, err)
+			}
+		}
+
+		limit := s.limit
+		if limit == nil {
+			if l, ok := opts.limits[path]; ok {
+				limit = &l
+			}
+		}
+		if limit != nil {
+			// the same limiter is shared by all methods of the path
+			h = res.limiter(path, *limit, opts.ips.ClientIP).Middleware(h)
+		}
+		for _, method := range methods {
+			if err := rt.Handle(method, path, h); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// paths from routes file replace built-in handlers with the same path
+	configured := map[string]bool{}
+	if opts.routesFile != "" {
+		config, err := loadRoutesConfig(opts.routesFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range config.Routes {
+			h, err := spec.handler(res)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", spec.Path, err)
+			}
+
+			settings := routeSettings{auth: spec.Auth}
+			if spec.Limit != "" {
+				l, err := parseRateLimit(spec.Limit)
+				if err != nil {
+					return nil, fmt.Errorf("route %s: %w", spec.Path, err)
+				}
+				settings.limit = &l
+			}
+			if spec.Cache != nil {
+				settings.cacheTTL = &spec.Cache.Duration
+			}
+
+			if err := handle(spec.methods(), spec.Path, h, settings); err != nil {
+				return nil, err
+			}
+			configured[spec.Path] = true
+		}
+	}
+
+	builtin := []builtinRoute{
+		{anyMethod, "/", newRootHandler()}, // Handler for all paths
+		{[]string{http.MethodPost, http.MethodPut}, "/echo", newEchoHandler()},
+		{[]string{http.MethodPost}, "/ungzip", newUngzipHandler()},
+		{anyMethod, "/anything", newInspectHandler()},
+		{anyMethod, "/anything/", newInspectHandler()},
+		{[]string{http.MethodGet}, "/cycle/{n...}", newCycleHandler()}, // matches /cycle/ too
+		{[]string{http.MethodGet}, "/events", newEventsHandler()},
+		{[]string{http.MethodGet}, "/ws/echo", newWSEchoHandler(opts.wsMaxMessage, opts.wsIdleTimeout)},
+	}
+
+	if opts.staticDir != "" {
+		root, err := res.openRoot(opts.staticDir)
+		if err != nil {
+			return nil, err
+		}
+		static := newStaticHandler(root, opts.staticListing)
+		// StripPrefix removes prefix from the path, so handler sees paths relative to the directory
+		builtin = append(builtin, builtinRoute{[]string{http.MethodGet}, opts.staticPrefix, http.StripPrefix(strings.TrimSuffix(opts.staticPrefix, "/"), static)})
+	}
+
+	if opts.blobsDir != "" {
+		blobs, err := newBlobStore(opts.blobsDir, opts.blobsMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		builtin = append(builtin,
+			builtinRoute{[]string{http.MethodPut}, "/blobs", errorHandlerFunc(blobs.Put)},
+			builtinRoute{[]string{http.MethodGet}, "/blobs/{sha256}", errorHandlerFunc(blobs.Get)},
+		)
+	}
+
+	if opts.proxyConfigFile != "" {
+		config, err := loadProxyConfig(opts.proxyConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range config.Routes {
+			proxy, err := newProxyHandler(route)
+			if err != nil {
+				return nil, err
+			}
+			builtin = append(builtin, builtinRoute{anyMethod, route.Prefix, proxy})
+		}
+	}
+
+	for _, b := range builtin {
+		if configured[b.path] {
+			continue
+		}
+		if err := handle(b.methods, b.path, b.handler, routeSettings{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return rt, nil
+}
+
 func main() {
-	mux := http.NewServeMux() // Creating new mux to manage handlers for different paths.
+	opts := &serverOptions{
+		// default limits, can be overridden by -limit flags
+		limits: routeLimits{
+			"/echo":   {Rate: 10, Burst: 20},
+			"/ungzip": {Rate: 5, Burst: 10},
+		},
+		auth: routeAuth{},
+		// default TTLs, can be overridden by -cache flags
+		cacheTTLs: routeTTLs{
+			"/cycle/{n...}": 30 * time.Second,
+		},
+	}
+
+	addr := flag.String("addr", ":8080", "address to listen")
+	adminAddr := flag.String("admin-addr", "", "address of admin page and pprof, for example localhost:9090, disabled if empty")
+	trustedProxies := flag.String("trusted-proxies", "", "comma-separated IPs or CIDRs of proxies allowed to set X-Forwarded-For")
+	h2c := flag.Bool("h2c", false, "serve HTTP/2 without TLS (h2c) in addition to HTTP/1.1")
+	accessLogEnabled := flag.Bool("access-log", true, "write line per request to stderr")
+	maxInFlight := flag.Int("max-inflight", 100, "maximum number of requests handled at the same time, 0 means no limit")
+	maxStreams := flag.Int("max-streams", 100, "maximum number of event streams and WebSocket connections open at the same time, they aren't counted in -max-inflight, 0 means no limit")
+	flag.StringVar(&opts.staticDir, "static-dir", "", "directory to serve, disabled if empty")
+	flag.StringVar(&opts.staticPrefix, "static-prefix", "/static/", "path prefix to serve static directory on")
+	flag.BoolVar(&opts.staticListing, "static-listing", false, "show listings of directories without index.html")
+	tlsCert := flag.String("tls-cert", "", "certificate file to serve HTTPS, reloaded on change")
+	tlsKey := flag.String("tls-key", "", "private key file of -tls-cert")
+	tlsSelfSigned := flag.Bool("tls-self-signed", false, "serve HTTPS with in-memory self-signed certificate for localhost (development only)")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA bundle to verify client certificates, enables mutual TLS")
+	flag.StringVar(&opts.proxyConfigFile, "proxy-config", "", "JSON file with path prefixes to proxy to upstreams")
+	flag.StringVar(&opts.routesFile, "routes", "", "JSON file with routing table, reloaded on SIGHUP")
+	flag.StringVar(&opts.blobsDir, "blobs-dir", "", "directory to store blobs uploaded with PUT /blobs, disabled if empty")
+	flag.Int64Var(&opts.blobsMaxSize, "blobs-max-size", defaultBlobMaxSize, "maximum size of uploaded blob in bytes")
+	flag.Int64Var(&opts.wsMaxMessage, "ws-max-message", defaultWSMaxMessage, "maximum size of WebSocket message in bytes")
+	flag.DurationVar(&opts.wsIdleTimeout, "ws-idle-timeout", defaultWSIdleTimeout, "time WebSocket connection is closed after if nothing is received, pings are sent twice as often")
+	flag.Var(opts.cacheTTLs, "cache", "time to cache responses of the route in form path=ttl, can be repeated, 0 disables caching")
+	cacheSize := flag.Int64("cache-size", defaultCacheSize, "maximum size of response cache in bytes, 0 disables caching")
+	cacheVary := flag.String("cache-vary", "Accept,Accept-Encoding", "comma-separated request headers cached responses can depend on")
+	flag.Var(opts.auth, "auth", "authentication schemes required by the route in form path=scheme[,scheme...], schemes are basic, bearer and hmac, can be repeated")
+	flag.StringVar(&opts.htpasswdFile, "htpasswd", "", "htpasswd file with bcrypt hashes for basic authentication, reloaded on SIGHUP")
+	flag.StringVar(&opts.bearerFile, "bearer-tokens", "", "file with name:token lines for bearer authentication, reloaded on SIGHUP")
+	flag.StringVar(&opts.hmacKeysFile, "hmac-keys", "", "file with keyID:secret lines for HMAC-signed requests, reloaded on SIGHUP")
+	hmacWindow := flag.Duration("hmac-window", defaultHMACWindow, "maximum difference between timestamp of HMAC-signed request and server time")
+	flag.Var(opts.limits, "limit", "per-route rate limit per client in form path=rate:burst, can be repeated")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins allowed to make cross-origin requests, \"*\" for any, CORS is disabled if empty")
+	corsHeaders := flag.String("cors-headers", "", "comma-separated request headers allowed in cross-origin requests, \"*\" for any")
+	corsExpose := flag.String("cors-expose", "", "comma-separated response headers exposed to cross-origin scripts")
+	corsCredentials := flag.Bool("cors-credentials", false, "allow cross-origin requests with credentials")
+	corsMaxAge := flag.Int("cors-max-age", 600, "seconds browsers can cache preflight response")
+	flag.Parse()
+
+	if opts.wsIdleTimeout <= 0 {
+		log.Fatalln("-ws-idle-timeout must be positive")
+	}
+
+	opts.cors = newCORSPolicy(*corsOrigins, *corsHeaders, *corsExpose, *corsCredentials, *corsMaxAge)
+
+	var err error
+	opts.ips, err = newClientIPResolver(*trustedProxies)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	opts.replays = newReplayGuard(*hmacWindow) // not recreated on reload, so signatures can't be replayed after it
+
+	if *cacheSize > 0 {
+		opts.cache = newResponseCache(*cacheSize, splitList(*cacheVary))
+	}
+
+	tlsConfig, err := newTLSConfig(*tlsCert, *tlsKey, *tlsSelfSigned, *tlsClientCA)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	rt, err := newRoutes(opts)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	routes := &swappableHandler{}
+	routes.Swap(rt)
+
+	// on SIGHUP router is rebuilt and replaced, if configuration is broken the old one is kept
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			rt, err := newRoutes(opts)
+			if err != nil {
+				log.Println("routes are not reloaded:", err)
+				continue
+			}
+			routes.Swap(rt)
+			if opts.cache != nil {
+				opts.cache.Purge() // reloaded handlers can respond differently
+			}
+			log.Println("routes are reloaded")
+		}
+	}()
+
+	var handler http.Handler = recoverPanics(routes)
+	if *maxInFlight > 0 || *maxStreams > 0 {
+		handler = limitInFlight(*maxInFlight, *maxStreams, handler)
+	}
+	if *accessLogEnabled {
+		handler = accessLog(handler)
+	}
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { // Handler for all paths
-		response := bytes.NewBuffer(nil)
+	if *adminAddr != "" {
+		stats := newRequestStats()
+		handler = stats.Middleware(handler)
 
-		response.WriteString(r.Method) // GET, POST and other HTTP methods
-		response.WriteString(" ")
-		response.WriteString("URI: ")
-		response.WriteString(r.RequestURI) // Request URI
-		response.WriteString(" ")
-		response.WriteString("handling with / handler")
-		response.WriteByte('\n')
+		currentRoutes := func() []routeInfo {
+			rt, _ := routes.Current().(*router)
+			return rt.Routes()
+		}
+		go func() {
+			fmt.Println("Starting admin server on " + *adminAddr)
+			log.Fatalln(http.ListenAndServe(*adminAddr, newAdminHandler(stats, currentRoutes)))
+		}()
+	}
+	handler = withTracing(handler) // the first one, so everything else has request ID in context
 
-		response.WriteTo(w) // or io.Copy(w, response)
-		// Note that w of type http.ResponseWriter implements io.Writer. it can be used with any code supports io.Writer
-	})
+	server := &http.Server{
+		Addr:      *addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
 
-	addr := ":8080"
+	if *h2c {
+		// HTTP/2 over TLS is enabled by default, unencrypted one must be enabled explicitly.
+		// Clients must use prior knowledge: upgrade from HTTP/1.1 is not supported.
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
 
-	fmt.Println("Starting server on " + addr)
+	fmt.Println("Starting server on " + *addr)
 
-	http.ListenAndServe(addr, mux)
+	if tlsConfig != nil {
+		// certificates are already in TLSConfig, so no files are passed here
+		log.Fatalln(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatalln(server.ListenAndServe())
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Errors which handlers can return (wrapped or not), they are mapped to status codes by statusOf.
+var (
+	ErrBadRequest       = errors.New("bad request")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrNotFound         = errors.New("not found")
+	ErrForbidden        = errors.New("forbidden")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrTooLarge         = errors.New("request entity too large")
+	ErrTooManyRequests  = errors.New("too many requests")
+	ErrBadGateway       = errors.New("bad gateway")
+	ErrGatewayTimeout   = errors.New("gateway timeout")
+)
+
+var sentinelStatuses = []struct {
+	err    error
+	status int
+}{
+	{ErrBadRequest, http.StatusBadRequest},
+	{ErrUnauthorized, http.StatusUnauthorized},
+	{ErrNotFound, http.StatusNotFound},
+	{fs.ErrNotExist, http.StatusNotFound},
+	{ErrForbidden, http.StatusForbidden},
+	{fs.ErrPermission, http.StatusForbidden},
+	{ErrMethodNotAllowed, http.StatusMethodNotAllowed},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge},
+	{ErrTooManyRequests, http.StatusTooManyRequests},
+	{ErrBadGateway, http.StatusBadGateway},
+	{ErrGatewayTimeout, http.StatusGatewayTimeout},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout},
+}
+
+// StatusError sets status code of the response explicitly.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status)
+	}
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// statusOf maps error to HTTP status code. Anything unknown is internal server error.
+func statusOf(err error) int {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	for _, s := range sentinelStatuses {
+		if errors.Is(err, s.err) {
+			return s.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// problem is problem details document described in RFC 7807.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeProblem responds with problem details describing err.
+// Details of internal errors are logged, but not sent to the client.
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	status := statusOf(err)
+
+	p := problem{
+		Type:      "about:blank", // means that title is just description of status code
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.Path,
+		RequestID: RequestIDFromContext(r.Context()),
+	}
+	if status >= http.StatusInternalServerError {
+		log.Printf("%s %s %s: %v", r.Method, r.URL, logPrefix(r.Context()), err)
+	} else {
+		p.Detail = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// errorHandlerFunc is handler which returns error instead of writing it to the response by its own.
+// Handler must not write anything to w if it returns error.
+type errorHandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (f errorHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f(w, r); err != nil {
+		writeProblem(w, r, err)
+	}
+}
+
+// recoverPanics turns panic in handler into 500 Internal Server Error instead of dropped connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// it's the way to abort response on purpose, let http.Server handle it
+				panic(rec)
+			}
+
+			log.Printf("panic in %s %s %s: %v\n%s", r.Method, r.URL, logPrefix(r.Context()), rec, debug.Stack())
+			// if handler has already written headers, status can't be changed, but it's all we can do
+			writeProblem(w, r, &StatusError{Status: http.StatusInternalServerError})
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// upstream is considered unhealthy after so many failures in a row
+	proxyMaxFails = 3
+	// unhealthy upstream doesn't get requests for this period
+	proxyFailTimeout = 30 * time.Second
+	// timeout to connect to upstream and to get response headers if it's not specified in config,
+	// response body isn't limited, so downloads and event streams can last as long as they need
+	proxyDefaultTimeout = 30 * time.Second
+)
+
+// proxyConfig is content of proxy configuration file, for example:
+//
+//	{
+//	  "routes": [
+//	    {
+//	      "prefix": "/api/",
+//	      "strip_prefix": true,
+//	      "timeout": "5s",
+//	      "upstreams": [
+//	        {"url": "http://127.0.0.1:9001"},
+//	        {"url": "http://127.0.0.1:9002", "timeout": "1s"}
+//	      ],
+//	      "request_headers": {"set": {"X-Gateway": "unit4"}, "remove": ["Cookie"]},
+//	      "response_headers": {"remove": ["Server"]}
+//	    }
+//	  ]
+//	}
+type proxyConfig struct {
+	Routes []proxyRoute `json:"routes"`
+}
+
+type proxyRoute struct {
+	Prefix          string          `json:"prefix"`
+	StripPrefix     bool            `json:"strip_prefix"`
+	Timeout         duration        `json:"timeout"`
+	Upstreams       []upstreamSpec  `json:"upstreams"`
+	RequestHeaders  headerRewriting `json:"request_headers"`
+	ResponseHeaders headerRewriting `json:"response_headers"`
+}
+
+type upstreamSpec struct {
+	URL     string   `json:"url"`
+	Timeout duration `json:"timeout"` // overrides timeout of the route
+}
+
+// headerRewriting is applied in order: remove, set, add.
+type headerRewriting struct {
+	Remove []string          `json:"remove"`
+	Set    map[string]string `json:"set"`
+	Add    map[string]string `json:"add"`
+}
+
+func (hr headerRewriting) apply(h http.Header) {
+	for _, name := range hr.Remove {
+		h.Del(name)
+	}
+	for name, value := range hr.Set {
+		h.Set(name, value)
+	}
+	for name, value := range hr.Add {
+		h.Add(name, value)
+	}
+}
+
+// duration is time.Duration which is written in config as string, for example "1m30s".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func loadProxyConfig(filename string) (*proxyConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &proxyConfig{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields() // typo in config must not be silently ignored
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", filename, err)
+	}
+	return config, nil
+}
+
+// upstream is a single backend of the route with its passive health state:
+// failures are counted on real requests, there are no additional health check requests.
+type upstream struct {
+	url       *url.URL
+	timeout   time.Duration
+	transport *http.Transport // has timeouts of this upstream
+
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+func (u *upstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !now.Before(u.downUntil)
+}
+
+func (u *upstream) success() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+}
+
+func (u *upstream) failure(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails++
+	if u.fails >= proxyMaxFails {
+		log.Printf("upstream %s is marked unhealthy for %s after %d failures", u.url, proxyFailTimeout, u.fails)
+		u.downUntil = now.Add(proxyFailTimeout)
+		u.fails = 0
+	}
+}
+
+type upstreamKey struct{}
+
+// proxyHandler forwards requests to upstreams of the route in round-robin order.
+type proxyHandler struct {
+	route     proxyRoute
+	upstreams []*upstream
+	next      atomic.Uint64
+	proxy     *httputil.ReverseProxy
+}
+
+func newProxyHandler(route proxyRoute) (*proxyHandler, error) {
+	if len(route.Upstreams) == 0 {
+		return nil, fmt.Errorf("route %s: no upstreams", route.Prefix)
+	}
+
+	h := &proxyHandler{route: route}
+
+	for _, spec := range route.Upstreams {
+		u, err := url.Parse(spec.URL)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: upstream %q: %w", route.Prefix, spec.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("route %s: upstream %q: scheme must be http or https", route.Prefix, spec.URL)
+		}
+
+		timeout := spec.Timeout.Duration
+		if timeout == 0 {
+			timeout = route.Timeout.Duration
+		}
+		if timeout == 0 {
+			timeout = proxyDefaultTimeout
+		}
+		h.upstreams = append(h.upstreams, &upstream{url: u, timeout: timeout, transport: newUpstreamTransport(timeout)})
+	}
+
+	h.proxy = &httputil.ReverseProxy{
+		Transport:      upstreamTransport{},
+		Rewrite:        h.rewrite,
+		ModifyResponse: h.modifyResponse,
+		ErrorHandler:   h.errorHandler,
+	}
+	return h, nil
+}
+
+// newUpstreamTransport limits connecting and waiting for response headers by timeout.
+// Timeout isn't set on the whole request: copying of response body can't be limited by it.
+func newUpstreamTransport(timeout time.Duration) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = timeout
+	t.ResponseHeaderTimeout = timeout
+	return t
+}
+
+// upstreamTransport sends request with transport of the upstream chosen for it.
+type upstreamTransport struct{}
+
+func (upstreamTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return r.Context().Value(upstreamKey{}).(*upstream).transport.RoundTrip(r)
+}
+
+// pick returns next healthy upstream. If all of them are unhealthy,
+// it's better to try any of them than to reject the request.
+func (h *proxyHandler) pick(now time.Time) *upstream {
+	n := uint64(len(h.upstreams))
+	start := h.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		u := h.upstreams[(start+i)%n]
+		if u.healthy(now) {
+			return u
+		}
+	}
+	return h.upstreams[start%n]
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := h.pick(time.Now())
+	h.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), upstreamKey{}, u)))
+}
+
+func (h *proxyHandler) rewrite(pr *httputil.ProxyRequest) {
+	u := pr.In.Context().Value(upstreamKey{}).(*upstream)
+
+	if h.route.StripPrefix {
+		pr.Out.URL.Path = "/" + strings.TrimPrefix(pr.Out.URL.Path, h.route.Prefix)
+		pr.Out.URL.RawPath = ""
+	}
+	pr.SetURL(u.url)
+	pr.SetXForwarded()
+	pr.Out.Host = u.url.Host
+
+	// upstream continues the same trace with our span as parent
+	if id := RequestIDFromContext(pr.In.Context()); id != "" {
+		pr.Out.Header.Set("X-Request-ID", id)
+	}
+	if tc, ok := TraceFromContext(pr.In.Context()); ok {
+		pr.Out.Header.Set("traceparent", tc.String())
+	}
+
+	h.route.RequestHeaders.apply(pr.Out.Header)
+}
+
+func (h *proxyHandler) modifyResponse(resp *http.Response) error {
+	u := resp.Request.Context().Value(upstreamKey{}).(*upstream)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		u.failure(time.Now())
+	} else {
+		u.success()
+	}
+
+	h.route.ResponseHeaders.apply(resp.Header)
+	return nil
+}
+
+func (h *proxyHandler) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	u := r.Context().Value(upstreamKey{}).(*upstream)
+
+	if r.Context().Err() == context.Canceled {
+		// client has gone, it's not upstream's fault
+		return
+	}
+
+	u.failure(time.Now())
+
+	// 5xx errors are logged by writeProblem with the cause, client gets only status
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		writeProblem(w, r, fmt.Errorf("%w: upstream %s: %w", ErrGatewayTimeout, u.url, err))
+		return
+	}
+	writeProblem(w, r, fmt.Errorf("%w: upstream %s: %w", ErrBadGateway, u.url, err))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBackend(t *testing.T, name string, status int) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", name)
+		w.Header().Set("X-Remove-Me", "1")
+		w.WriteHeader(status)
+		io.WriteString(w, name+" "+r.URL.Path+" "+r.Header.Get("X-Gateway"))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func newTestGateway(t *testing.T, routes ...proxyRoute) *httptest.Server {
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		h, err := newProxyHandler(route)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		mux.Handle(route.Prefix, h)
+	}
+	gateway := httptest.NewServer(mux)
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	resp, err := http.Get(url)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	a := newTestBackend(t, "a", http.StatusOK)
+	b := newTestBackend(t, "b", http.StatusOK)
+
+	gateway := newTestGateway(t, proxyRoute{
+		Prefix:    "/api/",
+		Upstreams: []upstreamSpec{{URL: a.URL}, {URL: b.URL}},
+	})
+
+	backends := []string{}
+	for i := 0; i < 4; i++ {
+		resp, _ := get(t, gateway.URL+"/api/x")
+		backends = append(backends, resp.Header.Get("X-Backend"))
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, backends)
+}
+
+func TestProxyRewriting(t *testing.T) {
+	a := newTestBackend(t, "a", http.StatusOK)
+
+	gateway := newTestGateway(t, proxyRoute{
+		Prefix:          "/api/",
+		StripPrefix:     true,
+		Upstreams:       []upstreamSpec{{URL: a.URL + "/v1"}},
+		RequestHeaders:  headerRewriting{Set: map[string]string{"X-Gateway": "unit4"}},
+		ResponseHeaders: headerRewriting{Remove: []string{"X-Remove-Me"}},
+	})
+
+	resp, body := get(t, gateway.URL+"/api/users/1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "a /v1/users/1 unit4", body)
+	assert.Empty(t, resp.Header.Get("X-Remove-Me"))
+}
+
+func TestProxyPassiveHealthCheck(t *testing.T) {
+	broken := newTestBackend(t, "broken", http.StatusInternalServerError)
+	good := newTestBackend(t, "good", http.StatusOK)
+
+	gateway := newTestGateway(t, proxyRoute{
+		Prefix:    "/",
+		Upstreams: []upstreamSpec{{URL: broken.URL}, {URL: good.URL}},
+	})
+
+	// every second request goes to broken upstream until it fails proxyMaxFails times
+	for i := 0; i < 2*proxyMaxFails; i++ {
+		get(t, gateway.URL+"/")
+	}
+
+	for i := 0; i < 4; i++ {
+		resp, _ := get(t, gateway.URL+"/")
+		assert.Equal(t, "good", resp.Header.Get("X-Backend"))
+	}
+}
+
+func TestProxyUpstreamErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close() // nothing listens there anymore
+
+	gateway := newTestGateway(t,
+		proxyRoute{Prefix: "/slow/", Upstreams: []upstreamSpec{{URL: slow.URL, Timeout: duration{50 * time.Millisecond}}}},
+		proxyRoute{Prefix: "/down/", Upstreams: []upstreamSpec{{URL: down.URL}}},
+	)
+
+	resp, _ := get(t, gateway.URL+"/slow/")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	resp, _ = get(t, gateway.URL+"/down/")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestProxyTimeoutDoesNotLimitBody(t *testing.T) {
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range 5 {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer streaming.Close()
+
+	gateway := newTestGateway(t,
+		proxyRoute{Prefix: "/stream/", Upstreams: []upstreamSpec{{URL: streaming.URL, Timeout: duration{50 * time.Millisecond}}}},
+	)
+
+	// response headers come at once, the body takes 150ms which is three times longer than timeout
+	resp, body := get(t, gateway.URL+"/stream/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "chunk 0\nchunk 1\nchunk 2\nchunk 3\nchunk 4\n", body)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit is token bucket configuration: every client gets Burst tokens
+// which are refilled with speed of Rate tokens per second. Each request takes one token.
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// String formats limit in the same form as it is parsed by parseRateLimit: "rate:burst".
+func (l rateLimit) String() string {
+	return strconv.FormatFloat(l.Rate, 'f', -1, 64) + ":" + strconv.Itoa(l.Burst)
+}
+
+func parseRateLimit(s string) (rateLimit, error) {
+	rate, burst, found := strings.Cut(s, ":")
+	if !found {
+		return rateLimit{}, fmt.Errorf("rate limit %q must be in form rate:burst", s)
+	}
+
+	l := rateLimit{}
+	var err error
+
+	l.Rate, err = strconv.ParseFloat(rate, 64)
+	if err != nil || l.Rate <= 0 {
+		return rateLimit{}, fmt.Errorf("rate limit %q: rate must be positive number", s)
+	}
+
+	l.Burst, err = strconv.Atoi(burst)
+	if err != nil || l.Burst < 1 {
+		return rateLimit{}, fmt.Errorf("rate limit %q: burst must be positive integer", s)
+	}
+	return l, nil
+}
+
+// routeLimits maps mux pattern to its rate limit.
+// It satisfies flag.Value, so limits can be set by repeating flag: -limit /echo=10:20 -limit /ungzip=1:5
+type routeLimits map[string]rateLimit
+
+func (rl routeLimits) String() string {
+	parts := make([]string, 0, len(rl))
+	for pattern, l := range rl {
+		parts = append(parts, pattern+"="+l.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (rl routeLimits) Set(s string) error {
+	pattern, limit, found := strings.Cut(s, "=")
+	if !found || pattern == "" {
+		return fmt.Errorf("route limit %q must be in form path=rate:burst", s)
+	}
+
+	l, err := parseRateLimit(limit)
+	if err != nil {
+		return err
+	}
+	rl[pattern] = l
+	return nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps token bucket for every client key returned by keyFunc.
+type rateLimiter struct {
+	limit   rateLimit
+	keyFunc func(r *http.Request) string
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit rateLimit, keyFunc func(r *http.Request) string) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		keyFunc: keyFunc,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes token from the bucket of the key.
+// If bucket is empty it returns false and duration after which the next token will be available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes buckets which are already refilled completely: they are no different from new ones.
+// Without it memory would grow with every new client.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	full := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(l.keyFunc(r), time.Now())
+		if !ok {
+			tooManyRequests(w, r, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitInFlight allows no more than maxRequests requests and maxStreams streams to be handled by next
+// at the same time, 0 means no limit. Requests over the limit are rejected immediately instead of waiting
+// in the queue. Stream is request which called startStream: it can last for hours, so it's moved to slots
+// of its own and idle streams can't take all slots of short requests.
+func limitInFlight(maxRequests, maxStreams int, next http.Handler) http.Handler {
+	l := &inFlightLimiter{}
+	// buffered channels work as semaphores, nil one means no limit
+	if maxRequests > 0 {
+		l.requests = make(chan struct{}, maxRequests)
+	}
+	if maxStreams > 0 {
+		l.streams = make(chan struct{}, maxStreams)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acquire(l.requests) {
+			tooManyRequests(w, r, time.Second)
+			return
+		}
+		slot := &inFlightSlot{limiter: l}
+		defer slot.release()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), inFlightKey{}, slot)))
+	})
+}
+
+type inFlightLimiter struct {
+	requests chan struct{}
+	streams  chan struct{}
+}
+
+type inFlightKey struct{}
+
+// inFlightSlot is slot taken by one request, it's used by handler goroutine only.
+type inFlightSlot struct {
+	limiter *inFlightLimiter
+	stream  bool
+}
+
+func (s *inFlightSlot) release() {
+	if s.stream {
+		release(s.limiter.streams)
+	} else {
+		release(s.limiter.requests)
+	}
+}
+
+// startStream moves request to stream slot, handler calls it before it starts streaming.
+// It returns false if all stream slots are taken, then handler must reject the request.
+func startStream(ctx context.Context) bool {
+	slot, ok := ctx.Value(inFlightKey{}).(*inFlightSlot)
+	if !ok || slot.stream {
+		return true // there is no limit or the slot is already moved
+	}
+	if !acquire(slot.limiter.streams) {
+		return false
+	}
+	release(slot.limiter.requests)
+	slot.stream = true
+	return true
+}
+
+func acquire(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func release(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	// Retry-After is measured in whole seconds, so round it up to not let client come back too early
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeProblem(w, r, ErrTooManyRequests)
+}
+
+// clientIPResolver finds out address of the client.
+// X-Forwarded-For is honored only if request came from one of trusted proxies,
+// otherwise anyone could pretend to be any other client by setting the header.
+type clientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// newClientIPResolver parses comma-separated list of trusted proxies, either IPs or CIDRs.
+func newClientIPResolver(proxies string) (*clientIPResolver, error) {
+	c := &clientIPResolver{}
+	for _, p := range strings.Split(proxies, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", p, err)
+		}
+		c.trusted = append(c.trusted, n)
+	}
+	return c, nil
+}
+
+func (c *clientIPResolver) isTrusted(ip net.IP) bool {
+	for _, n := range c.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns address of the client made the request.
+// X-Forwarded-For is walked from right to left: every proxy appends address of its peer,
+// so the first untrusted address is the one which connected to our outermost trusted proxy.
+func (c *clientIPResolver) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !c.isTrusted(ip) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		hopIP := net.ParseIP(hop)
+		if hopIP == nil {
+			// garbage in the header, can't trust anything to the left of it
+			return host
+		}
+		host = hop
+		if !c.isTrusted(hopIP) {
+			break
+		}
+	}
+	return host
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	l := newRateLimiter(rateLimit{Rate: 2, Burst: 3}, nil)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		key   string
+		after time.Duration // since start
+		ok    bool
+		wait  time.Duration
+	}{
+		// burst is available at once
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, false, 500 * time.Millisecond},
+		// other clients have their own buckets
+		{"b", 0, true, 0},
+		// token is refilled in 1/rate seconds
+		{"a", 200 * time.Millisecond, false, 300 * time.Millisecond},
+		{"a", 500 * time.Millisecond, true, 0},
+		{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		// refill doesn't go over burst
+		{"a", time.Hour, true, 0},
+		{"a", time.Hour, true, 0},
+		{"a", time.Hour, true, 0},
+		{"a", time.Hour, false, 500 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		ok, wait := l.allow(tt.key, start.Add(tt.after))
+		assert.Equal(t, tt.ok, ok, "request %d", i)
+		assert.Equal(t, tt.wait, wait, "request %d", i)
+	}
+
+	// refilled buckets are swept
+	l.allow("a", start.Add(2*time.Hour))
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestTooManyRequestsRetryAfter(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "1"},
+		{time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1001 * time.Millisecond, "2"},
+		{2500 * time.Millisecond, "3"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tooManyRequests(w, httptest.NewRequest(http.MethodGet, "/", nil), tt.wait)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, tt.want, w.Header().Get("Retry-After"), tt.wait.String())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	resolver, err := newClientIPResolver("10.0.0.0/8, 192.168.1.1, ::1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"no proxy", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer can't set client", "203.0.113.7:1234", []string{"1.1.1.1"}, "203.0.113.7"},
+		{"trusted peer without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"trusted peer", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted IPv6 peer", "[::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"198.51.100.1, 192.168.1.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed hops left of client are ignored", "10.0.0.1:1234", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers are one list", "10.0.0.1:1234", []string{"1.1.1.1", "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed hop in the first header", "10.0.0.1:1234", []string{"1.1.1.1, 198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"all hops trusted", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbage hop", "10.0.0.1:1234", []string{"1.1.1.1, not-an-ip"}, "10.0.0.1"},
+		{"garbage left of trusted hop", "10.0.0.1:1234", []string{"junk, 10.0.0.2"}, "10.0.0.2"},
+		{"garbage left of client", "10.0.0.1:1234", []string{"junk, 198.51.100.1"}, "198.51.100.1"},
+		{"empty hop", "10.0.0.1:1234", []string{"1.1.1.1,,198.51.100.1"}, "198.51.100.1"},
+		{"empty header", "10.0.0.1:1234", []string{""}, "10.0.0.1"},
+		{"port in hop", "10.0.0.1:1234", []string{"198.51.100.1:5678"}, "10.0.0.1"},
+		{"remote address without port", "10.0.0.1", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.want, resolver.ClientIP(r))
+		})
+	}
+
+	_, err = newClientIPResolver("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestLimitInFlightStreams(t *testing.T) {
+	block := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/events", newEventsHandler())
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) { <-block })
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(limitInFlight(1, 1, mux))
+	defer server.Close()
+	defer close(block)
+
+	get := func(ctx context.Context, path string) *http.Response {
+		r, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		resp, err := http.DefaultClient.Do(r)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return resp
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := get(ctx, "/events?interval=100ms")
+	assert.Equal(t, http.StatusOK, stream.StatusCode)
+	line, err := bufio.NewReader(stream.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "id: 0"), line)
+
+	// the stream doesn't hold slot of requests, but there is no slot for another stream
+	resp := get(context.Background(), "/")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = get(context.Background(), "/events")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	cancel()
+	stream.Body.Close()
+	assert.Eventually(t, func() bool {
+		resp := get(context.Background(), "/events?count=1&interval=100ms")
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	// ordinary requests still share one slot
+	go func() {
+		if resp, err := http.Get(server.URL + "/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	assert.Eventually(t, func() bool {
+		resp := get(context.Background(), "/")
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusTooManyRequests
+	}, time.Second, 10*time.Millisecond)
+}
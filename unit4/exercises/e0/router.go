@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// router registers handlers per method and path on top of http.ServeMux.
+// Path can have wildcards supported by ServeMux, for example "/blobs/{sha256}",
+// handlers get their values with r.PathValue("sha256").
+//
+// For every path router also registers handler without method, which is picked by ServeMux
+// for methods without own handler. It answers OPTIONS requests and CORS preflights,
+// and responds 405 Method Not Allowed with Allow header to anything else.
+type router struct {
+	mux   *http.ServeMux
+	paths map[string]*pathRoutes
+	cors  *corsPolicy
+}
+
+func newRouter(cors *corsPolicy) *router {
+	return &router{
+		mux:   http.NewServeMux(),
+		paths: map[string]*pathRoutes{},
+		cors:  cors,
+	}
+}
+
+// Handle registers handler for method and path. Empty method means any method
+// which has no handler of its own.
+func (rt *router) Handle(method, path string, h http.Handler) (err error) {
+	// ServeMux panics on invalid or conflicting patterns, turn it to error:
+	// routes can be loaded from file at runtime and broken file must not crash the server.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("route %s %s: %v", method, path, r)
+		}
+	}()
+
+	p, ok := rt.paths[path]
+	if !ok {
+		p = &pathRoutes{cors: rt.cors}
+		rt.mux.Handle(path, p)
+		rt.paths[path] = p
+	}
+
+	if method == "" {
+		if p.any != nil {
+			return fmt.Errorf("route %s: handler for any method is already registered", path)
+		}
+		p.any = h
+		return nil
+	}
+
+	method = strings.ToUpper(method)
+	rt.mux.Handle(method+" "+path, h)
+	p.methods = append(p.methods, method)
+	return nil
+}
+
+func (rt *router) HandleFunc(method, path string, h http.HandlerFunc) error {
+	return rt.Handle(method, path, h)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rt.cors != nil {
+		rt.cors.setHeaders(w, r)
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// pathRoutes is handler of the path for methods which have no handler of its own.
+type pathRoutes struct {
+	methods []string
+	any     http.Handler
+	cors    *corsPolicy
+}
+
+// allowed returns value of Allow header. Empty string means any method is allowed.
+func (p *pathRoutes) allowed() string {
+	if p.any != nil {
+		return ""
+	}
+	methods := slices.Clone(p.methods)
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		// ServeMux routes HEAD to GET handler
+		methods = append(methods, http.MethodHead)
+	}
+	if !slices.Contains(methods, http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+	return strings.Join(methods, ", ")
+}
+
+func (p *pathRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" && p.cors != nil {
+		p.cors.preflight(w, r, p.allowed())
+		return
+	}
+
+	if p.any != nil {
+		p.any.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", p.allowed())
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeProblem(w, r, fmt.Errorf("%w: %s", ErrMethodNotAllowed, r.Method))
+}
+
+// corsPolicy describes which cross-origin requests are allowed by browsers.
+type corsPolicy struct {
+	Origins     []string // "*" allows any origin
+	Headers     []string // request headers allowed in addition to CORS-safelisted ones
+	Expose      []string // response headers exposed to scripts
+	Credentials bool
+	MaxAge      int // seconds browsers can cache preflight response
+}
+
+// newCORSPolicy returns nil if no origins are allowed: CORS is disabled then.
+func newCORSPolicy(origins, headers, expose string, credentials bool, maxAge int) *corsPolicy {
+	if origins == "" {
+		return nil
+	}
+	return &corsPolicy{
+		Origins:     splitList(origins),
+		Headers:     splitList(headers),
+		Expose:      splitList(expose),
+		Credentials: credentials,
+		MaxAge:      maxAge,
+	}
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (c *corsPolicy) allowOrigin(origin string) bool {
+	return origin != "" && (slices.Contains(c.Origins, "*") || slices.Contains(c.Origins, origin))
+}
+
+// setHeaders adds CORS headers to response of allowed origin.
+func (c *corsPolicy) setHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if !c.allowOrigin(origin) {
+		return
+	}
+
+	if slices.Contains(c.Origins, "*") && !c.Credentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		// wildcard is not allowed by browsers for requests with credentials
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.Expose) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.Expose, ", "))
+	}
+}
+
+// preflight answers preflight request. allow is list of methods of the path, empty means any method.
+func (c *corsPolicy) preflight(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !c.allowOrigin(r.Header.Get("Origin")) || (allow != "" && !slices.Contains(strings.Split(allow, ", "), method)) {
+		// no Access-Control-Allow-* headers means browser won't send actual request
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	for _, h := range splitList(r.Header.Get("Access-Control-Request-Headers")) {
+		if !slices.ContainsFunc(c.Headers, func(allowed string) bool { return allowed == "*" || strings.EqualFold(allowed, h) }) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	if allow == "" {
+		allow = method
+	}
+	w.Header().Set("Access-Control-Allow-Methods", allow)
+	if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		// all of them are checked above
+		w.Header().Set("Access-Control-Allow-Headers", requested)
+	}
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeInfo describes registered path for admin page.
+type routeInfo struct {
+	Path    string `json:"path"`
+	Methods string `json:"methods"` // value of Allow header, empty means any method
+}
+
+// Routes returns registered paths sorted.
+func (rt *router) Routes() []routeInfo {
+	routes := make([]routeInfo, 0, len(rt.paths))
+	for path, p := range rt.paths {
+		routes = append(routes, routeInfo{Path: path, Methods: p.allowed()})
+	}
+	slices.SortFunc(routes, func(a, b routeInfo) int { return strings.Compare(a.Path, b.Path) })
+	return routes
+}
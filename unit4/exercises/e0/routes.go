@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// routesConfig is content of routing table file, for example:
+//
+//	{
+//	  "routes": [
+//	    {"path": "/echo", "methods": ["POST", "PUT"], "type": "echo", "limit": "10:20"},
+//	    {"path": "/cycle/{n...}", "type": "cycle", "cache": "1m"},
+//	    {"path": "/private/", "type": "static", "dir": "/srv/private", "auth": ["basic", "bearer"]},
+//	    {"path": "/files/", "type": "static", "dir": "/var/www", "listing": true},
+//	    {"path": "/old", "type": "redirect", "location": "/new", "status": 308},
+//	    {"path": "/health", "methods": ["GET"], "type": "fixed", "status": 200,
+//	     "headers": {"Content-Type": "text/plain"}, "body": "ok\n"},
+//	    {"path": "/api/", "type": "proxy", "proxy": {"strip_prefix": true, "upstreams": [{"url": "http://127.0.0.1:9001"}]}}
+//	  ]
+//	}
+type routesConfig struct {
+	Routes []routeSpec `json:"routes"`
+}
+
+// routeSpec maps path and methods to one of built-in handler types.
+// Only fields of the chosen type are used.
+type routeSpec struct {
+	Path    string    `json:"path"`
+	Methods []string  `json:"methods"` // any method if empty
+	Type    string    `json:"type"`
+	Limit   string    `json:"limit"` // rate limit per client in form rate:burst
+	Cache   *duration `json:"cache"` // time to cache responses for, for example "30s"
+	Auth    []string  `json:"auth"`  // authentication schemes, any of them is accepted
+
+	// static
+	Dir     string `json:"dir"`
+	Listing bool   `json:"listing"`
+
+	// redirect and fixed
+	Location string            `json:"location"`
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers"`
+	Body     string            `json:"body"`
+
+	// proxy, prefix is taken from path
+	Proxy *proxyRoute `json:"proxy"`
+}
+
+func loadRoutesConfig(filename string) (*routesConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &routesConfig{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", filename, err)
+	}
+	return config, nil
+}
+
+// methods returns methods of the route. If they are not set in the file,
+// handlers which make sense only for some methods get them by default.
+func (spec routeSpec) methods() []string {
+	if len(spec.Methods) > 0 {
+		return spec.Methods
+	}
+	switch spec.Type {
+	case "echo":
+		return []string{http.MethodPost, http.MethodPut}
+	case "ungzip":
+		return []string{http.MethodPost}
+	case "static":
+		return []string{http.MethodGet}
+	}
+	return []string{""} // any method
+}
+
+// handler builds handler of the route type.
+func (spec routeSpec) handler(res *routeResources) (http.Handler, error) {
+	switch spec.Type {
+	case "echo":
+		return newEchoHandler(), nil
+	case "ungzip":
+		return newUngzipHandler(), nil
+	case "anything":
+		return newInspectHandler(), nil
+	case "cycle":
+		return newCycleHandler(), nil
+	case "events":
+		return newEventsHandler(), nil
+	case "static":
+		root, err := res.openRoot(spec.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return http.StripPrefix(strings.TrimSuffix(spec.Path, "/"), newStaticHandler(root, spec.Listing)), nil
+	case "redirect":
+		if spec.Location == "" {
+			return nil, fmt.Errorf("redirect requires location")
+		}
+		status := spec.Status
+		if status == 0 {
+			status = http.StatusFound
+		}
+		if status < 300 || status > 399 {
+			return nil, fmt.Errorf("redirect status must be 3xx, got %d", status)
+		}
+		return http.RedirectHandler(spec.Location, status), nil
+	case "fixed":
+		return newFixedHandler(spec.Status, spec.Headers, spec.Body), nil
+	case "proxy":
+		if spec.Proxy == nil {
+			return nil, fmt.Errorf("proxy requires proxy settings")
+		}
+		route := *spec.Proxy
+		route.Prefix = spec.Path
+		return newProxyHandler(route)
+	default:
+		return nil, fmt.Errorf("unknown route type %q", spec.Type)
+	}
+}
+
+// newFixedHandler always responds with the same status, headers and body.
+func newFixedHandler(status int, headers map[string]string, body string) http.HandlerFunc {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write([]byte(body))
+		}
+	}
+}
+
+// routeResources are kept between routers rebuilt on reload. Rate limiters keep their buckets, so reload
+// doesn't give every client full burst back. Directories are opened once: os.Root of replaced router
+// would never be closed otherwise, and every reload would leak file descriptor.
+type routeResources struct {
+	limiters map[string]*rateLimiter // by path
+	roots    map[string]*os.Root     // by directory
+	prev     *routeResources         // resources of the current router until the new one is built
+}
+
+// newRouteResources collects resources of new router, it reuses ones of prev, which can be nil.
+func newRouteResources(prev *routeResources) *routeResources {
+	return &routeResources{
+		limiters: map[string]*rateLimiter{},
+		roots:    map[string]*os.Root{},
+		prev:     prev,
+	}
+}
+
+// limiter returns rate limiter of the path, the previous one is reused if the limit hasn't changed.
+func (res *routeResources) limiter(path string, limit rateLimit, keyFunc func(r *http.Request) string) *rateLimiter {
+	if l, ok := res.limiters[path]; ok && l.limit == limit {
+		return l
+	}
+	l, ok := res.prev.orEmpty().limiters[path]
+	if !ok || l.limit != limit {
+		l = newRateLimiter(limit, keyFunc)
+	}
+	res.limiters[path] = l
+	return l
+}
+
+// openRoot opens directory or returns os.Root of it which the previous router has.
+func (res *routeResources) openRoot(dir string) (*os.Root, error) {
+	dir = filepath.Clean(dir)
+	if root, ok := res.roots[dir]; ok {
+		return root, nil
+	}
+	root, ok := res.prev.orEmpty().roots[dir]
+	if !ok {
+		var err error
+		if root, err = os.OpenRoot(dir); err != nil {
+			return nil, err
+		}
+	}
+	res.roots[dir] = root
+	return root, nil
+}
+
+// orEmpty returns res or empty resources if it's nil.
+func (res *routeResources) orEmpty() *routeResources {
+	if res == nil {
+		return newRouteResources(nil)
+	}
+	return res
+}
+
+// commit closes directories which only the previous router uses, it's called once new router is built.
+// Requests to the routes which are removed can fail until the new router replaces the previous one.
+func (res *routeResources) commit() {
+	for dir, root := range res.prev.orEmpty().roots {
+		if _, ok := res.roots[dir]; !ok {
+			root.Close()
+		}
+	}
+	res.prev = nil
+}
+
+// discard closes directories opened for router which failed to build.
+func (res *routeResources) discard() {
+	prev := res.prev.orEmpty()
+	for dir, root := range res.roots {
+		if _, ok := prev.roots[dir]; !ok {
+			root.Close()
+		}
+	}
+}
+
+// swappableHandler passes requests to the current handler which can be replaced at any time.
+// Requests in progress are finished by previous handler, so no connection is dropped.
+type swappableHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func (s *swappableHandler) Swap(h http.Handler) {
+	s.current.Store(&h)
+}
+
+// Current returns handler which serves requests now.
+func (s *swappableHandler) Current() http.Handler {
+	return *s.current.Load()
+}
+
+func (s *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.current.Load()).ServeHTTP(w, r)
+}
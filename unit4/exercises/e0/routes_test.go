@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadKeepsResources(t *testing.T) {
+	dir := t.TempDir()
+	if !assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644)) {
+		t.FailNow()
+	}
+	opts := &serverOptions{
+		staticDir:    dir,
+		staticPrefix: "/static/",
+		limits:       routeLimits{"/echo": {Rate: 0.001, Burst: 1}},
+		ips:          &clientIPResolver{},
+		replays:      newReplayGuard(time.Minute),
+		auth:         routeAuth{},
+		cacheTTLs:    routeTTLs{},
+	}
+	echo := func(h http.Handler) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/echo", nil))
+		return w.Code
+	}
+
+	first, err := newRoutes(opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	root := opts.resources.roots[dir]
+	assert.Equal(t, http.StatusOK, echo(first))
+
+	// reloaded router has the same directory and buckets
+	second, err := newRoutes(opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Same(t, root, opts.resources.roots[dir])
+	assert.Equal(t, http.StatusTooManyRequests, echo(second))
+
+	// changed limit gets new bucket, failed reload keeps everything
+	opts.limits["/echo"] = rateLimit{Rate: 0.001, Burst: 2}
+	opts.routesFile = filepath.Join(dir, "missing.json")
+	_, err = newRoutes(opts)
+	assert.Error(t, err)
+	opts.routesFile = ""
+	third, err := newRoutes(opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusOK, echo(third))
+	assert.Same(t, root, opts.resources.roots[dir])
+	_, err = root.Stat(".")
+	assert.NoError(t, err)
+
+	// directory which isn't used anymore is closed
+	opts.staticDir = ""
+	_, err = newRoutes(opts)
+	assert.NoError(t, err)
+	assert.Empty(t, opts.resources.roots)
+	_, err = root.Stat(".")
+	assert.Error(t, err)
+}
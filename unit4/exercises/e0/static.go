@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxGzipSize limits files compressed on-the-fly: compression is done in memory.
+const maxGzipSize = 10 << 20
+
+// maxGzipCacheSize limits total size of compressed files kept in memory, files over it are compressed
+// on every request.
+const maxGzipCacheSize = 64 << 20
+
+// staticHandler serves files from the directory.
+// Range and conditional requests are handled by http.ServeContent, the handler provides strong ETag for it.
+type staticHandler struct {
+	root    fs.FS
+	listing bool
+
+	mu        sync.Mutex
+	etags     map[string]etagEntry
+	gzipBytes int64 // total size of gzipped in etags
+}
+
+// etagEntry caches file hash until file is changed, so file is not read twice on every request.
+// Compressed content is cached as well: revalidation, HEAD and Range requests need it
+// before http.ServeContent checks preconditions, and compression is expensive.
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+	gzipped []byte // nil until it's requested
+}
+
+func (e etagEntry) matches(info fs.FileInfo) bool {
+	return e.size == info.Size() && e.modTime.Equal(info.ModTime())
+}
+
+// newStaticHandler serves directory of root. Files are opened with os.Root, so neither ".." nor symlinks
+// can escape the directory. Root is opened by routeResources, it's shared by routers rebuilt on reload.
+func newStaticHandler(root *os.Root, listing bool) *staticHandler {
+	return &staticHandler{
+		root:    root.FS(),
+		listing: listing,
+		etags:   map[string]etagEntry{},
+	}
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, ErrMethodNotAllowed)
+		return
+	}
+
+	// path is cleaned by ServeMux in most cases, but handler can be mounted anywhere, so check it explicitly
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		if segment == ".." {
+			writeProblem(w, r, fmt.Errorf("%w: invalid path", ErrBadRequest))
+			return
+		}
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	f, err := h.root.Open(name)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+
+	if info.IsDir() {
+		if r.URL.Path != "" && !strings.HasSuffix(r.URL.Path, "/") {
+			// relative links in the listing and index.html only work with trailing slash.
+			// Location is relative and set as is: the handler is behind StripPrefix, http.Redirect
+			// would resolve it against the stripped path and send the client outside of the mount.
+			location := path.Base(r.URL.Path) + "/"
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(w, r, name)
+		return
+	}
+
+	h.serveFile(w, r, name, f, info)
+}
+
+func (h *staticHandler) error(w http.ResponseWriter, r *http.Request, err error) {
+	// errors are not sent as is: they have paths on the server in their messages
+	if errors.Is(err, fs.ErrPermission) {
+		writeProblem(w, r, ErrForbidden)
+		return
+	}
+	// os.Root returns errors for paths escaping the root, they are not found for the client
+	writeProblem(w, r, ErrNotFound)
+}
+
+func (h *staticHandler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	index := path.Join(name, "index.html")
+	if f, err := h.root.Open(index); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && !info.IsDir() {
+			h.serveFile(w, r, index, f, info)
+			return
+		}
+	}
+
+	if !h.listing {
+		writeProblem(w, r, fmt.Errorf("%w: directory listing is disabled", ErrForbidden))
+		return
+	}
+
+	entries, err := fs.ReadDir(h.root, name)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		// directories first, then files, both sorted by name
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	listingTemplate.Execute(w, struct {
+		Path    string
+		Entries []fs.DirEntry
+	}{r.URL.Path, entries})
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+<li><a href="../">../</a></li>
+{{- range .Entries}}
+{{- if .IsDir}}
+<li><a href="{{.Name}}/">{{.Name}}/</a></li>
+{{- else}}
+<li><a href="{{.Name}}">{{.Name}}</a></li>
+{{- end}}
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, f fs.File, info fs.FileInfo) {
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		writeProblem(w, r, fmt.Errorf("file %s can't be seeked", name))
+		return
+	}
+
+	etag, err := h.etag(name, content, info)
+	if err != nil {
+		writeProblem(w, r, &StatusError{Status: http.StatusInternalServerError, Err: err})
+		return
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if isCompressible(ctype) && info.Size() <= maxGzipSize && acceptsGzip(r) {
+		if compressed, err := h.gzipped(name, content, info); err == nil {
+			// compressed representation is different set of bytes, so it must have its own strong ETag
+			w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+			w.Header().Set("Content-Encoding", "gzip")
+			http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(compressed))
+			return
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			writeProblem(w, r, &StatusError{Status: http.StatusInternalServerError, Err: err})
+			return
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	// ServeContent handles Range, If-None-Match, If-Modified-Since and other conditional headers
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// etag returns strong ETag based on SHA-256 of file content.
+func (h *staticHandler) etag(name string, content io.ReadSeeker, info fs.FileInfo) (string, error) {
+	h.mu.Lock()
+	e, ok := h.etags[name]
+	h.mu.Unlock()
+	if ok && e.matches(info) {
+		return e.etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+
+	h.mu.Lock()
+	h.gzipBytes -= int64(len(h.etags[name].gzipped))
+	h.etags[name] = etagEntry{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	h.mu.Unlock()
+
+	return etag, nil
+}
+
+// gzipped returns compressed content of the file. It's called after etag, so the entry of the file
+// is there unless the file has just been changed.
+func (h *staticHandler) gzipped(name string, content io.ReadSeeker, info fs.FileInfo) ([]byte, error) {
+	h.mu.Lock()
+	e, ok := h.etags[name]
+	h.mu.Unlock()
+	if ok && e.matches(info) && e.gzipped != nil {
+		return e.gzipped, nil
+	}
+
+	compressed := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(compressed)
+	if _, err := io.Copy(zw, content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.etags[name]; ok && e.matches(info) && e.gzipped == nil && h.gzipBytes+int64(compressed.Len()) <= maxGzipCacheSize {
+		e.gzipped = compressed.Bytes()
+		h.etags[name] = e
+		h.gzipBytes += int64(len(e.gzipped))
+	}
+	return compressed.Bytes(), nil
+}
+
+func isCompressible(ctype string) bool {
+	mediatype, _, _ := mime.ParseMediaType(ctype)
+	if strings.HasPrefix(mediatype, "text/") {
+		return true
+	}
+	switch mediatype {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+			if strings.TrimSpace(coding) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+				return true
+			}
+		}
+	}
+	return false
+}
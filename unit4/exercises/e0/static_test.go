@@ -0,0 +1,159 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestStatic serves directory with a.txt, sub/b.txt and secret.txt next to the directory,
+// link.txt is symlink to the secret.
+func newTestStatic(t *testing.T) (*staticHandler, string) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "root")
+	for name, content := range map[string]string{
+		"root/a.txt":     "hello, static world",
+		"root/sub/b.txt": "b",
+		"secret.txt":     "top secret content",
+	} {
+		filename := filepath.Join(base, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755)) ||
+			!assert.NoError(t, os.WriteFile(filename, []byte(content), 0o644)) {
+			t.FailNow()
+		}
+	}
+
+	if !assert.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(dir, "link.txt"))) {
+		t.FailNow()
+	}
+
+	root, err := os.OpenRoot(dir)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { root.Close() })
+	return newStaticHandler(root, true), dir
+}
+
+// serveStatic serves request with the handler mounted on /static/ as in newRoutes.
+func serveStatic(h *staticHandler, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	http.StripPrefix("/static", h).ServeHTTP(w, r)
+	return w
+}
+
+func TestStaticRedirectsDirectory(t *testing.T) {
+	h, _ := newTestStatic(t)
+
+	w := serveStatic(h, "/static/sub?sort=name", nil)
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	// relative to /static/sub, so it's /static/sub/
+	assert.Equal(t, "sub/?sort=name", w.Header().Get("Location"))
+
+	w = serveStatic(h, "/static/sub/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<a href="b.txt">`)
+}
+
+func TestStaticStaysInRoot(t *testing.T) {
+	h, _ := newTestStatic(t)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/static/a.txt", http.StatusOK},
+		{"/static/../secret.txt", http.StatusBadRequest},
+		{"/static/%2e%2e/secret.txt", http.StatusBadRequest},
+		{"/static/sub/%2E%2E/%2e%2e/secret.txt", http.StatusBadRequest},
+		{"/static/sub/..%2f..%2fsecret.txt", http.StatusBadRequest},
+		{"/static/link.txt", http.StatusNotFound},
+		{"/static/missing.txt", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := serveStatic(h, tt.target, nil)
+		assert.Equal(t, tt.status, w.Code, tt.target)
+		assert.NotContains(t, w.Body.String(), "top secret", tt.target)
+	}
+}
+
+func TestStaticConditionalAndRangeRequests(t *testing.T) {
+	h, _ := newTestStatic(t)
+
+	w := serveStatic(h, "/static/a.txt", nil)
+	assert.Equal(t, "hello, static world", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{64}"$`, etag)
+
+	w = serveStatic(h, "/static/a.txt", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = serveStatic(h, "/static/a.txt", http.Header{"If-Modified-Since": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = serveStatic(h, "/static/a.txt", http.Header{"If-Modified-Since": {time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serveStatic(h, "/static/a.txt", http.Header{"Range": {"bytes=7-12"}})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "static", w.Body.String())
+	assert.Equal(t, "bytes 7-12/19", w.Header().Get("Content-Range"))
+
+	// range doesn't apply if the file has changed since the client got it
+	w = serveStatic(h, "/static/a.txt", http.Header{"Range": {"bytes=7-12"}, "If-Range": {`"other"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello, static world", w.Body.String())
+}
+
+func TestStaticGzip(t *testing.T) {
+	h, dir := newTestStatic(t)
+	gzipped := http.Header{"Accept-Encoding": {"br, gzip"}}
+
+	w := serveStatic(h, "/static/a.txt", gzipped)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{64}-gzip"$`, etag)
+	zr, err := gzip.NewReader(w.Body)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(zr)
+		assert.Equal(t, "hello, static world", string(body))
+	}
+
+	// compressed content is kept, so revalidation doesn't compress the file again
+	assert.NotNil(t, h.etags["a.txt"].gzipped)
+	w = serveStatic(h, "/static/a.txt", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// ETag of compressed content doesn't match identity one and vice versa
+	w = serveStatic(h, "/static/a.txt", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	w = serveStatic(h, "/static/a.txt", http.Header{"Accept-Encoding": {"gzip;q=0"}})
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+	// changed file is compressed again
+	if !assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed"), 0o644)) {
+		t.FailNow()
+	}
+	w = serveStatic(h, "/static/a.txt", gzipped)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	zr, err = gzip.NewReader(w.Body)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(zr)
+		assert.Equal(t, "changed", string(body))
+	}
+	assert.Equal(t, int64(len(h.etags["a.txt"].gzipped)), h.gzipBytes)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often certificate files are checked for changes.
+const certCheckInterval = time.Second
+
+// certReloader loads certificate and key from files and reloads them once files are changed,
+// so certificates can be rotated without restart of the server.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads certificate if any of files was modified after previous load.
+func (c *certReloader) reload(now time.Time) error {
+	c.lastCheck = now
+
+	var modTime time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate, it is called on every TLS handshake.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := time.Now(); now.Sub(c.lastCheck) >= certCheckInterval {
+		if err := c.reload(now); err != nil {
+			// files can be in the middle of rotation, keep serving previous certificate
+			log.Println("certificate reload failed:", err)
+		}
+	}
+	return c.cert, nil
+}
+
+// generateSelfSigned creates certificate for hosts signed by its own ed25519 key.
+// The certificate lives only in memory and is meant for development only.
+func generateSelfSigned(hosts []string) (*tls.Certificate, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour), // tolerate clock skew of clients
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
+}
+
+// newTLSConfig builds TLS configuration from flags. It returns nil config if TLS is not enabled.
+func newTLSConfig(certFile, keyFile string, selfSigned bool, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch {
+	case selfSigned && (certFile != "" || keyFile != ""):
+		return nil, fmt.Errorf("self-signed mode can't be used together with certificate files")
+	case selfSigned:
+		cert, err := generateSelfSigned([]string{"localhost", "127.0.0.1", "::1"})
+		if err != nil {
+			return nil, fmt.Errorf("generate self-signed certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{*cert}
+	case certFile != "" && keyFile != "":
+		reloader, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate: %w", err)
+		}
+		config.GetCertificate = reloader.GetCertificate
+	case certFile != "" || keyFile != "":
+		return nil, fmt.Errorf("both certificate and key files must be specified")
+	default:
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("client CA requires TLS to be enabled")
+		}
+		return nil, nil
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("load client CA: no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
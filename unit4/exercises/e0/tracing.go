@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxRequestIDLength limits X-Request-ID accepted from clients, longer ones are replaced with generated.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+type traceKey struct{}
+
+// traceContext is W3C Trace Context carried in traceparent header:
+// https://www.w3.org/TR/trace-context/#traceparent-header
+type traceContext struct {
+	TraceID  string // 32 hex digits, the same for all services handling the request
+	ParentID string // span ID of the caller, empty if trace is started by us
+	SpanID   string // 16 hex digits, our own span
+	Flags    string // 2 hex digits, for example "01" means sampled
+}
+
+// String formats traceparent header value to be passed to the next service: our span is its parent.
+func (tc traceContext) String() string {
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + tc.Flags
+}
+
+// parseTraceparent parses traceparent header of version 00.
+func parseTraceparent(s string) (traceContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 {
+		return traceContext{}, fmt.Errorf("traceparent %q must have 4 parts", s)
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	if version != "00" {
+		return traceContext{}, fmt.Errorf("traceparent %q: unsupported version", s)
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return traceContext{}, fmt.Errorf("traceparent %q: invalid trace id", s)
+	}
+	if !isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return traceContext{}, fmt.Errorf("traceparent %q: invalid parent id", s)
+	}
+	if !isLowerHex(flags, 2) {
+		return traceContext{}, fmt.Errorf("traceparent %q: invalid flags", s)
+	}
+
+	return traceContext{TraceID: traceID, ParentID: parentID, Flags: flags}, nil
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(bytes int) string {
+	b := make([]byte, bytes)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts only short printable IDs: they are written to logs and response headers as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withTracing accepts X-Request-ID and traceparent headers from the client or generates new ones.
+// They are stored in request context for the handlers and echoed in response headers.
+func withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = randomHex(16)
+		}
+
+		tc, err := parseTraceparent(r.Header.Get("traceparent"))
+		if err != nil {
+			// invalid or missing traceparent: trace is started here
+			tc = traceContext{TraceID: randomHex(16), Flags: "01"}
+		}
+		tc.SpanID = randomHex(8)
+
+		w.Header().Set("X-Request-ID", id)
+		w.Header().Set("traceparent", tc.String())
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, traceKey{}, tc)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns ID of the request handled with the context, or empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// TraceFromContext returns trace context of the request handled with the context.
+func TraceFromContext(ctx context.Context) (traceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(traceContext)
+	return tc, ok
+}
+
+// logPrefix formats request and trace IDs to be added to log lines.
+func logPrefix(ctx context.Context) string {
+	prefix := "request_id=" + RequestIDFromContext(ctx)
+	if tc, ok := TraceFromContext(ctx); ok {
+		prefix += " trace_id=" + tc.TraceID + " span_id=" + tc.SpanID
+	}
+	return prefix
+}
+
+// statusRecorder remembers status code and size of the response for access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush, Hijack and other methods of the original writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// accessLog writes line per request after it's handled.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK // nothing was written, net/http responds with 200 then
+		}
+		log.Printf("%s %s %s %s %d %d %s %s", r.RemoteAddr, r.Method, r.RequestURI, r.Proto,
+			rec.status, rec.bytes, time.Since(start).Round(time.Microsecond), logPrefix(r.Context()))
+	})
+}
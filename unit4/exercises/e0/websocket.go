@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// wsGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept, it's defined in RFC 6455.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultWSMaxMessage limits size of the message assembled from all its fragments.
+const defaultWSMaxMessage = 1 << 20
+
+// defaultWSIdleTimeout is how long connection can be silent. Server pings the client twice as often,
+// so connection of live client never times out, even if it sends no messages.
+const defaultWSIdleTimeout = time.Minute
+
+// frame opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// close status codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseNoStatus      = 1005 // never sent, means that close frame had no status
+	wsCloseInvalidData   = 1007
+	wsCloseTooBig        = 1009
+)
+
+// wsCloseError is returned by ReadMessage when connection is closed by the peer or because of protocol violation.
+type wsCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Reason)
+}
+
+// wsConn is server side of WebSocket connection.
+type wsConn struct {
+	conn        net.Conn
+	rw          *bufio.ReadWriter
+	maxMessage  int64
+	idleTimeout time.Duration
+	done        chan struct{} // closed by Close, stops pings
+	closeOnce   sync.Once
+
+	writeMu   sync.Mutex // pings are written concurrently with messages
+	closeSent bool
+}
+
+// upgradeWebSocket performs opening handshake and takes over the connection from http.Server.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxMessage int64, idleTimeout time.Duration) (*wsConn, error) {
+	if r.Method != http.MethodGet {
+		return nil, fmt.Errorf("%w: websocket handshake must be GET request", ErrMethodNotAllowed)
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("%w: not a websocket handshake", ErrBadRequest)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, fmt.Errorf("%w: unsupported websocket version", ErrBadRequest)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadRequest)
+	}
+	// connection can stay open for hours, so it must not hold slot of short requests
+	if !startStream(r.Context()) {
+		return nil, fmt.Errorf("%w: too many open streams", ErrTooManyRequests)
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: can't take over connection: %w", err)
+	}
+	// server timeouts are for HTTP requests, deadlines of frames are set by readFrame and writeFrame
+	conn.SetDeadline(time.Time{})
+
+	hash := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(hash[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &wsConn{conn: conn, rw: rw, maxMessage: maxMessage, idleTimeout: idleTimeout, done: make(chan struct{})}
+	go c.keepAlive()
+	return c, nil
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
+
+// keepAlive pings the client until connection is closed. Pongs are frames as well, so they move
+// read deadline, and connection of client which has gone without closing it times out.
+func (c *wsConn) keepAlive() {
+	ticker := time.NewTicker(c.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads single frame. Client frames must be masked, payload is unmasked here.
+// remaining is how many bytes the message can still grow, it's checked before payload is read.
+func (c *wsConn) readFrame(remaining int64) (fin bool, opcode byte, payload []byte, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "reserved bits are set"}
+	}
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	if !masked {
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "client frame is not masked"}
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if opcode >= wsOpClose {
+		if !fin || length > 125 {
+			return false, 0, nil, &wsCloseError{wsCloseProtocolError, "control frame is fragmented or too long"}
+		}
+	} else if length > uint64(remaining) {
+		return false, 0, nil, &wsCloseError{wsCloseTooBig, "message is too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes single unfragmented frame. Server frames are never masked.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked writes frame with writeMu locked, client which doesn't read makes it time out.
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.idleTimeout))
+
+	header := []byte{0x80 | opcode, 0}
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// WriteMessage sends text or binary message.
+func (c *wsConn) WriteMessage(opcode byte, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+// CloseWith sends close frame with the code and the reason.
+func (c *wsConn) CloseWith(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+
+	payload := []byte{}
+	if code != wsCloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrameLocked(wsOpClose, payload)
+}
+
+// ReadMessage returns the next text or binary message assembled from its fragments.
+// Pings are answered and pongs are skipped on the way. When peer closes connection
+// or violates the protocol, close frame is sent back and *wsCloseError is returned.
+func (c *wsConn) ReadMessage() (opcode byte, data []byte, err error) {
+	opcode, data, err = c.readMessage()
+
+	var closeErr *wsCloseError
+	if errors.As(err, &closeErr) {
+		code := closeErr.Code
+		if code == wsCloseNoStatus {
+			code = wsCloseNormal
+		}
+		c.CloseWith(code, "")
+	}
+	return opcode, data, err
+}
+
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var (
+		opcode  byte
+		message []byte
+		started bool
+	)
+
+	for {
+		fin, op, payload, err := c.readFrame(c.maxMessage - int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return 0, nil, parseClosePayload(payload)
+		case wsOpText, wsOpBinary:
+			if started {
+				return 0, nil, &wsCloseError{wsCloseProtocolError, "new message before previous is finished"}
+			}
+			started = true
+			opcode = op
+		case wsOpContinuation:
+			if !started {
+				return 0, nil, &wsCloseError{wsCloseProtocolError, "continuation frame without message"}
+			}
+		default:
+			return 0, nil, &wsCloseError{wsCloseProtocolError, fmt.Sprintf("unknown opcode %#x", op)}
+		}
+
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+
+		if opcode == wsOpText && !utf8.Valid(message) {
+			return 0, nil, &wsCloseError{wsCloseInvalidData, "text message is not valid UTF-8"}
+		}
+		return opcode, message, nil
+	}
+}
+
+func parseClosePayload(payload []byte) *wsCloseError {
+	switch {
+	case len(payload) == 0:
+		return &wsCloseError{Code: wsCloseNoStatus}
+	case len(payload) == 1:
+		return &wsCloseError{wsCloseProtocolError, "close frame payload is too short"}
+	}
+
+	code := int(binary.BigEndian.Uint16(payload))
+	reason := payload[2:]
+	if code < 1000 || code == wsCloseNoStatus || code == 1006 || code == 1015 || !utf8.Valid(reason) {
+		return &wsCloseError{wsCloseProtocolError, "invalid close frame"}
+	}
+	return &wsCloseError{Code: code, Reason: string(reason)}
+}
+
+// newWSEchoHandler sends every text and binary message back to the client.
+func newWSEchoHandler(maxMessage int64, idleTimeout time.Duration) errorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		conn, err := upgradeWebSocket(w, r, maxMessage, idleTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return nil // connection is hijacked, there is nobody to report errors to
+			}
+			if err := conn.WriteMessage(opcode, data); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wsTestClient is minimal client side of WebSocket: it masks frames as RFC 6455 requires from clients.
+type wsTestClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWSEcho(t *testing.T, maxMessage int64) *wsTestClient {
+	return dialWS(t, newWSEchoHandler(maxMessage, defaultWSIdleTimeout))
+}
+
+func dialWS(t *testing.T, h http.Handler) *wsTestClient {
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(conn, "GET /ws/echo HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	// the value from the example in RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	return &wsTestClient{conn: conn, r: r}
+}
+
+func (c *wsTestClient) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte) {
+	header := []byte{opcode, 0x80}
+	if fin {
+		header[0] |= 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		header[1] |= byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] |= 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header[1] |= 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+
+	frame := append(append(header, mask...), masked...)
+	_, err := c.conn.Write(frame)
+	assert.NoError(t, err)
+}
+
+func (c *wsTestClient) readFrame(t *testing.T) (byte, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, byte(0), header[1]&0x80, "server frames must not be masked")
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	payload := make([]byte, length)
+	_, err := io.ReadFull(c.r, payload)
+	assert.NoError(t, err)
+	return header[0] & 0x0F, payload
+}
+
+func closeCode(payload []byte) int {
+	if len(payload) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func TestWSEchoMessages(t *testing.T) {
+	c := dialWSEcho(t, defaultWSMaxMessage)
+
+	c.writeFrame(t, true, wsOpText, []byte("hello"))
+	op, data := c.readFrame(t)
+	assert.Equal(t, byte(wsOpText), op)
+	assert.Equal(t, "hello", string(data))
+
+	binaryData := make([]byte, 70000) // needs 64-bit length
+	rand.Read(binaryData)
+	c.writeFrame(t, true, wsOpBinary, binaryData)
+	op, data = c.readFrame(t)
+	assert.Equal(t, byte(wsOpBinary), op)
+	assert.Equal(t, binaryData, data)
+
+	// fragmented message with ping in the middle of it
+	c.writeFrame(t, false, wsOpText, []byte("frag"))
+	c.writeFrame(t, true, wsOpPing, []byte("ping!"))
+	c.writeFrame(t, true, wsOpContinuation, []byte("mented"))
+
+	op, data = c.readFrame(t)
+	assert.Equal(t, byte(wsOpPong), op)
+	assert.Equal(t, "ping!", string(data))
+
+	op, data = c.readFrame(t)
+	assert.Equal(t, byte(wsOpText), op)
+	assert.Equal(t, "fragmented", string(data))
+
+	c.writeFrame(t, true, wsOpClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal))
+	op, data = c.readFrame(t)
+	assert.Equal(t, byte(wsOpClose), op)
+	assert.Equal(t, wsCloseNormal, closeCode(data))
+}
+
+func TestWSEchoProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame func(t *testing.T, c *wsTestClient)
+		code  int
+	}{
+		{"too big", func(t *testing.T, c *wsTestClient) {
+			c.writeFrame(t, true, wsOpBinary, make([]byte, 200))
+		}, wsCloseTooBig},
+		{"invalid utf-8", func(t *testing.T, c *wsTestClient) {
+			c.writeFrame(t, true, wsOpText, []byte{0xff, 0xfe})
+		}, wsCloseInvalidData},
+		{"unexpected continuation", func(t *testing.T, c *wsTestClient) {
+			c.writeFrame(t, true, wsOpContinuation, []byte("x"))
+		}, wsCloseProtocolError},
+		{"fragmented ping", func(t *testing.T, c *wsTestClient) {
+			c.writeFrame(t, false, wsOpPing, nil)
+		}, wsCloseProtocolError},
+		{"unmasked frame", func(t *testing.T, c *wsTestClient) {
+			c.conn.Write([]byte{0x80 | wsOpText, 1, 'x'})
+		}, wsCloseProtocolError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialWSEcho(t, 100)
+			tt.frame(t, c)
+
+			op, data := c.readFrame(t)
+			assert.Equal(t, byte(wsOpClose), op)
+			assert.Equal(t, tt.code, closeCode(data))
+		})
+	}
+}
+
+func TestWSEchoHandshakeRejected(t *testing.T) {
+	server := httptest.NewServer(newWSEchoHandler(defaultWSMaxMessage, defaultWSIdleTimeout))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestWSEchoPingsAndTimesOut(t *testing.T) {
+	c := dialWS(t, newWSEchoHandler(defaultWSMaxMessage, 200*time.Millisecond))
+
+	// client which answers pings stays connected longer than idle timeout
+	for range 4 {
+		op, data := c.readFrame(t)
+		assert.Equal(t, byte(wsOpPing), op)
+		c.writeFrame(t, true, wsOpPong, data)
+	}
+	c.writeFrame(t, true, wsOpText, []byte("still here"))
+	op, data := c.readFrame(t)
+	for op == wsOpPing {
+		op, data = c.readFrame(t)
+	}
+	assert.Equal(t, byte(wsOpText), op)
+	assert.Equal(t, "still here", string(data))
+
+	// silent client is disconnected
+	start := time.Now()
+	_, err := io.Copy(io.Discard, c.r)
+	assert.NoError(t, err, "connection must be closed by server")
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// value is number computed by backend, every backend has its own types of values.
+type value any
+
+// backend is arithmetic evaluator computes with. Parser and errors are the same for all backends:
+// operations fail with ErrIntOverflow, ErrDivideByZero and ErrNotValidInteger.
+type backend interface {
+	Parse(literal string) (value, error)
+	Format(v value) string
+
+	Neg(a value) (value, error)
+	Add(a, b value) (value, error)
+	Sub(a, b value) (value, error)
+	Mul(a, b value) (value, error)
+	Div(a, b value) (value, error)
+	Mod(a, b value) (value, error)
+	Pow(a, b value) (value, error)
+	Sqrt(a value) (value, error)
+
+	// Cmp compares numbers like cmp.Compare, so NaN is less than any number.
+	Cmp(a, b value) int
+}
+
+// nativeBackend computes with Go numbers: int, decimal and float64. Operation on different types
+// promotes arguments to the wider one: int to decimal, int and decimal to float64.
+//
+// Integers and decimals have integer semantics: overflow and division by zero are errors.
+// Floats follow IEEE 754: division by zero gives infinity and 0/0 gives NaN.
+type nativeBackend struct {
+	precision int // digits after decimal point in output of decimals and floats, negative means as is
+}
+
+// Parse treats literal with exponent as float and literal with decimal point as decimal.
+func (nativeBackend) Parse(literal string) (value, error) {
+	switch {
+	case strings.ContainsAny(literal, "eE"):
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %w", literal, ErrNotValidInteger)
+		}
+		return f, nil
+	case strings.Contains(literal, "."):
+		return parseDecimal(literal)
+	}
+
+	n, err := strconv.Atoi(literal)
+	if err != nil {
+		return nil, fmt.Errorf("argument %q: %w", literal, ErrNotValidInteger)
+	}
+	return n, nil
+}
+
+func (nb nativeBackend) Format(v value) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case decimal:
+		if nb.precision < 0 {
+			return v.String()
+		}
+		if nb.precision < v.scale {
+			return v.round(nb.precision).String()
+		}
+		if r, err := v.rescale(nb.precision); err == nil {
+			return r.String()
+		}
+		return v.String()
+	case float64:
+		if nb.precision < 0 {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'f', nb.precision, 64)
+	}
+	panic(fmt.Sprintf("unknown value %T", v))
+}
+
+// numberKind orders types by promotion: the wider type is greater.
+type numberKind int
+
+const (
+	kindInt numberKind = iota
+	kindDecimal
+	kindFloat
+)
+
+func kindOf(v value) numberKind {
+	switch v.(type) {
+	case decimal:
+		return kindDecimal
+	case float64:
+		return kindFloat
+	}
+	return kindInt
+}
+
+func toDecimal(v value) decimal {
+	if n, ok := v.(int); ok {
+		return decimal{units: n}
+	}
+	return v.(decimal)
+}
+
+func toFloat(v value) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case decimal:
+		return v.float()
+	}
+	return v.(float64)
+}
+
+// binary promotes arguments to the same type and calls function of the type.
+func binary(a, b value,
+	ints func(x, y int) (int, error),
+	decimals func(x, y decimal) (decimal, error),
+	floats func(x, y float64) float64,
+) (value, error) {
+	var (
+		r   value
+		err error
+	)
+	switch max(kindOf(a), kindOf(b)) {
+	case kindInt:
+		r, err = ints(a.(int), b.(int))
+	case kindDecimal:
+		r, err = decimals(toDecimal(a), toDecimal(b))
+	default:
+		return floats(toFloat(a), toFloat(b)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (nativeBackend) Neg(a value) (value, error) {
+	switch a := a.(type) {
+	case int:
+		return neg(a)
+	case decimal:
+		return negDecimal(a)
+	}
+	return -a.(float64), nil
+}
+
+func (nativeBackend) Add(a, b value) (value, error) {
+	return binary(a, b, add, addDecimal, func(x, y float64) float64 { return x + y })
+}
+
+func (nativeBackend) Sub(a, b value) (value, error) {
+	return binary(a, b, sub, subDecimal, func(x, y float64) float64 { return x - y })
+}
+
+func (nativeBackend) Mul(a, b value) (value, error) {
+	return binary(a, b, mul, mulDecimal, func(x, y float64) float64 { return x * y })
+}
+
+// Div computes quotient of decimals with at least as many digits as output precision has.
+func (nb nativeBackend) Div(a, b value) (value, error) {
+	decimals := func(x, y decimal) (decimal, error) { return divDecimal(x, y, nb.precision) }
+	return binary(a, b, div, decimals, func(x, y float64) float64 { return x / y })
+}
+
+func (nativeBackend) Mod(a, b value) (value, error) {
+	return binary(a, b, mod, modDecimal, math.Mod)
+}
+
+// Pow keeps integer semantics only for non-negative integer exponent,
+// otherwise result is float: 2 ^ -1 is 0.5 and 2 ^ 0.5 is square root of 2.
+func (nativeBackend) Pow(a, b value) (value, error) {
+	n, ok := b.(int)
+	if !ok || n < 0 || kindOf(a) == kindFloat {
+		return math.Pow(toFloat(a), toFloat(b)), nil
+	}
+	if d, ok := a.(decimal); ok {
+		return powDecimal(d, n)
+	}
+	return pow(a.(int), n)
+}
+
+// Sqrt of integer is integer if it's perfect square, otherwise it's float: sqrt 16 is 4, sqrt 2 is 1.4142135623730951.
+// Square root of negative integer or decimal is error, of negative float it's NaN.
+func (nativeBackend) Sqrt(a value) (value, error) {
+	switch a := a.(type) {
+	case int:
+		if a < 0 {
+			return nil, fmt.Errorf("error in expression sqrt %d: negative argument", a)
+		}
+		if r, ok := exactSqrt(big.NewInt(int64(a))); ok {
+			return int(r.Int64()), nil
+		}
+	case decimal:
+		if a.units < 0 {
+			return nil, fmt.Errorf("error in expression sqrt %s: negative argument", a)
+		}
+	}
+	return math.Sqrt(toFloat(a)), nil
+}
+
+func (nativeBackend) Cmp(a, b value) int {
+	switch max(kindOf(a), kindOf(b)) {
+	case kindInt:
+		return cmp.Compare(a.(int), b.(int))
+	case kindDecimal:
+		if x, y, err := align(toDecimal(a), toDecimal(b)); err == nil {
+			return cmp.Compare(x.units, y.units)
+		}
+		// too many digits to align, floats are precise enough to compare so large numbers
+	}
+	return cmp.Compare(toFloat(a), toFloat(b))
+}
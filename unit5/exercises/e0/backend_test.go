@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// evalLine evaluates single expression line with the backend and formats its result.
+func evalLine(num backend, line string) (string, error) {
+	stmt, err := parseLine(line)
+	if err != nil {
+		return "", err
+	}
+	v, _, err := newEvaluator(num).exec(stmt)
+	if err != nil {
+		return "", err
+	}
+	return num.Format(v), nil
+}
+
+func TestBackends(t *testing.T) {
+	tests := []struct {
+		line   string
+		intRes string
+		intErr error
+		bigRes string
+	}{
+		{line: "mul 2 2", intRes: "4", bigRes: "4"},
+		{line: "pow 2 62", intRes: "4611686018427387904", bigRes: "4611686018427387904"},
+		{line: "pow 2 63", intErr: ErrIntOverflow, bigRes: "9223372036854775808"},
+		{line: "pow 2 200", intErr: ErrIntOverflow, bigRes: "1606938044258990275541962092341162602522202993782792835301376"},
+		{line: "mul 10000000000 10000000000", intErr: ErrIntOverflow, bigRes: "100000000000000000000"},
+		{line: "9223372036854775807 + 1", intErr: ErrIntOverflow, bigRes: "9223372036854775808"},
+		{line: "-(-9223372036854775808)", intErr: ErrIntOverflow, bigRes: "9223372036854775808"},
+		{line: "add 99999999999999999999 1", intErr: ErrNotValidInteger, bigRes: "100000000000000000000"},
+		{line: "div 6 3", intRes: "2", bigRes: "2"},
+		{line: "div 7 2", intRes: "3", bigRes: "7/2"},
+		{line: "(1 / 3) * 3", intRes: "0", bigRes: "1"},
+		{line: "2 ^ 3 ^ 2 - 1", intRes: "511", bigRes: "511"},
+		{line: "-7 % 3", intRes: "-1", bigRes: "-1"},
+		{line: "pow 3 100 / pow 3 98", intErr: ErrIntOverflow, bigRes: "9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := evalLine(nativeBackend{precision: -1}, tt.line)
+			if tt.intErr != nil {
+				assert.ErrorIs(t, err, tt.intErr)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tt.intRes, got)
+			}
+
+			got, err = evalLine(bigBackend{precision: -1}, tt.line)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.bigRes, got)
+			}
+		})
+	}
+}
+
+func TestBigBackendErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want error
+	}{
+		{"div 1 0", ErrDivideByZero},
+		{"1 / (2 - 2)", ErrDivideByZero},
+		{"mod 1 0", ErrDivideByZero},
+		{"0 ^ -1", ErrDivideByZero},
+		{"pow 10 100000000", ErrIntOverflow},
+		{"x * 2", ErrUndefinedVariable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			_, err := evalLine(bigBackend{precision: -1}, tt.line)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	got, err := evalLine(bigBackend{precision: -1}, "2 ^ -2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1/4", got)
+
+	got, err = evalLine(bigBackend{precision: -1}, "(-1) ^ 100000000001")
+	assert.NoError(t, err)
+	assert.Equal(t, "-1", got)
+}
+
+func TestNumberTypes(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"12.50 + 1", "13.50"},
+		{"0.1 + 0.2", "0.3"},
+		{"1.5 * 1.5", "2.25"},
+		{"1.0 * 1.0", "1.0"},
+		{"10.00 / 3", "3.33"},
+		{"-2.5 / 2", "-1.3"},
+		{"7.5 % 2", "1.5"},
+		{"1.5 ^ 2", "2.25"},
+		{"-(0.5)", "-0.5"},
+		{"1e3", "1000"},
+		{"1.5e-3 * 2", "0.003"},
+		{"1e3 + 0.5", "1000.5"},
+		{"2 ^ 0.5", "1.4142135623730951"},
+		{"2 ^ -1", "0.5"},
+		{"1.0 / 0.0e0", "+Inf"},
+		{"-1 / 0e0", "-Inf"},
+		{"0e0 / 0", "NaN"},
+		{"mod 7e0 2", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := evalLine(nativeBackend{precision: -1}, tt.line)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+
+	for _, line := range []string{"1.5 / 0", "mod 2.5 0.0", "9223372036854775.807 * 10000"} {
+		_, err := evalLine(nativeBackend{precision: -1}, line)
+		assert.Error(t, err, line)
+	}
+	_, err := evalLine(nativeBackend{precision: -1}, "1.5 / 0")
+	assert.ErrorIs(t, err, ErrDivideByZero)
+	_, err = evalLine(nativeBackend{precision: -1}, "9223372036854775.807 * 10000")
+	assert.ErrorIs(t, err, ErrIntOverflow)
+}
+
+func TestPrecision(t *testing.T) {
+	tests := []struct {
+		num  backend
+		line string
+		want string
+	}{
+		{nativeBackend{precision: 2}, "10 / 3", "3"},
+		{nativeBackend{precision: 2}, "10.000 / 3", "3.33"},
+		{nativeBackend{precision: 3}, "10.00 / 3", "3.333"},
+		{nativeBackend{precision: 4}, "2.0 / 8", "0.2500"},
+		{nativeBackend{precision: 2}, "2.675 + 0", "2.68"},
+		{nativeBackend{precision: 2}, "1.5", "1.50"},
+		{nativeBackend{precision: 0}, "2.5", "3"},
+		{nativeBackend{precision: 3}, "2 ^ 0.5", "1.414"},
+		{bigBackend{precision: 3}, "1 / 3", "0.333"},
+		{bigBackend{precision: 3}, "2 / 3", "0.667"},
+		{bigBackend{precision: 3}, "6 / 3", "2"},
+		{bigBackend{precision: -1}, "12.50 + 1", "27/2"},
+		{bigBackend{precision: -1}, "1e-3 * 1000", "1"},
+		{bigBackend{precision: 2}, "0.1 + 0.2", "0.30"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := evalLine(tt.num, tt.line)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// maxBigBits limits size of numbers in bigint mode: "pow 10 1000000000" would take all the memory.
+const maxBigBits = 1 << 24
+
+// bigBackend computes with arbitrary-precision integers. Division which isn't exact
+// gives fraction, so its values are *big.Int or *big.Rat. Fraction with denominator 1
+// is always turned back to integer.
+type bigBackend struct {
+	precision int // digits after decimal point in output of fractions, negative means to print them as n/d
+}
+
+// Parse reads decimals and floats as exact fractions: 12.50 is 25/2 and 1e-3 is 1/1000.
+func (bigBackend) Parse(literal string) (value, error) {
+	if n, ok := new(big.Int).SetString(literal, 10); ok {
+		return n, nil
+	}
+	r, ok := new(big.Rat).SetString(literal)
+	if !ok || r.Num().BitLen()+r.Denom().BitLen() > maxBigBits {
+		return nil, fmt.Errorf("argument %q: %w", literal, ErrNotValidInteger)
+	}
+	return normalize(r), nil
+}
+
+func (bb bigBackend) Format(v value) string {
+	switch v := v.(type) {
+	case *big.Int:
+		return v.String()
+	case *big.Rat:
+		if bb.precision >= 0 {
+			return v.FloatString(bb.precision)
+		}
+		return v.RatString()
+	}
+	panic(fmt.Sprintf("unknown value %T", v))
+}
+
+func toRat(v value) *big.Rat {
+	if n, ok := v.(*big.Int); ok {
+		return new(big.Rat).SetInt(n)
+	}
+	return v.(*big.Rat)
+}
+
+// normalize turns fraction with denominator 1 to integer.
+func normalize(r *big.Rat) value {
+	if r.IsInt() {
+		return new(big.Int).Set(r.Num())
+	}
+	return r
+}
+
+func checkBits(v value, format string, a, b value) (value, error) {
+	bits := 0
+	switch v := v.(type) {
+	case *big.Int:
+		bits = v.BitLen()
+	case *big.Rat:
+		bits = v.Num().BitLen() + v.Denom().BitLen()
+	}
+	if bits > maxBigBits {
+		return nil, fmt.Errorf("error in expression "+format+": %w", a, b, ErrIntOverflow)
+	}
+	return v, nil
+}
+
+// intOrRat calls intOp if both arguments are integers, or ratOp otherwise.
+func intOrRat(a, b value, intOp func(x, y *big.Int) *big.Int, ratOp func(x, y *big.Rat) *big.Rat) value {
+	x, xInt := a.(*big.Int)
+	y, yInt := b.(*big.Int)
+	if xInt && yInt {
+		return intOp(x, y)
+	}
+	return normalize(ratOp(toRat(a), toRat(b)))
+}
+
+func (bigBackend) Neg(a value) (value, error) {
+	switch a := a.(type) {
+	case *big.Int:
+		return new(big.Int).Neg(a), nil
+	case *big.Rat:
+		return new(big.Rat).Neg(a), nil
+	}
+	panic(fmt.Sprintf("unknown value %T", a))
+}
+
+func (bigBackend) Add(a, b value) (value, error) {
+	r := intOrRat(a, b,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Add(x, y) },
+		func(x, y *big.Rat) *big.Rat { return new(big.Rat).Add(x, y) })
+	return checkBits(r, "%v + %v", a, b)
+}
+
+func (bigBackend) Sub(a, b value) (value, error) {
+	r := intOrRat(a, b,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Sub(x, y) },
+		func(x, y *big.Rat) *big.Rat { return new(big.Rat).Sub(x, y) })
+	return checkBits(r, "%v - %v", a, b)
+}
+
+func (bigBackend) Mul(a, b value) (value, error) {
+	r := intOrRat(a, b,
+		func(x, y *big.Int) *big.Int { return new(big.Int).Mul(x, y) },
+		func(x, y *big.Rat) *big.Rat { return new(big.Rat).Mul(x, y) })
+	return checkBits(r, "%v * %v", a, b)
+}
+
+func isZero(v value) bool {
+	return toRat(v).Sign() == 0
+}
+
+// Div is exact: 6 / 3 is 2, but 1 / 3 is fraction 1/3.
+func (bigBackend) Div(a, b value) (value, error) {
+	if isZero(b) {
+		return nil, fmt.Errorf("error in expression %v / %v: %w", a, b, ErrDivideByZero)
+	}
+	r := normalize(new(big.Rat).Quo(toRat(a), toRat(b)))
+	return checkBits(r, "%v / %v", a, b)
+}
+
+// Mod is remainder of truncated division like % of Go. Only integers have it.
+func (bigBackend) Mod(a, b value) (value, error) {
+	x, xInt := a.(*big.Int)
+	y, yInt := b.(*big.Int)
+	if !xInt || !yInt {
+		return nil, fmt.Errorf("error in expression %v %% %v: remainder of fraction", a, b)
+	}
+	if y.Sign() == 0 {
+		return nil, fmt.Errorf("error in expression %v %% %v: %w", a, b, ErrDivideByZero)
+	}
+	return new(big.Int).Rem(x, y), nil
+}
+
+// Pow takes integer exponent. Negative exponent gives fraction: 2 ^ -2 is 1/4.
+func (bigBackend) Pow(a, b value) (value, error) {
+	exp, ok := b.(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("error in expression %v ^ %v: exponent is not integer", a, b)
+	}
+
+	base := toRat(a)
+	if exp.Sign() < 0 {
+		if base.Sign() == 0 {
+			return nil, fmt.Errorf("error in expression %v ^ %v: %w", a, b, ErrDivideByZero)
+		}
+		base = new(big.Rat).Inv(base)
+	}
+
+	// size of the result is known before it's computed: bits of base times exponent
+	absExp := new(big.Int).Abs(exp)
+	bits := new(big.Int).Mul(big.NewInt(int64(base.Num().BitLen()+base.Denom().BitLen())), absExp)
+	if base.Num().CmpAbs(base.Denom()) != 0 && bits.Cmp(big.NewInt(maxBigBits)) > 0 {
+		// only 1 and -1 can be raised to huge power
+		return nil, fmt.Errorf("error in expression %v ^ %v: %w", a, b, ErrIntOverflow)
+	}
+
+	num := new(big.Int).Exp(base.Num(), absExp, nil)
+	denom := new(big.Int).Exp(base.Denom(), absExp, nil)
+	return normalize(new(big.Rat).SetFrac(num, denom)), nil
+}
+
+// Sqrt is exact, so only perfect squares have it: sqrt 16 is 4 and sqrt 9/4 is 3/2, but sqrt 2 is error.
+func (bigBackend) Sqrt(a value) (value, error) {
+	r := toRat(a)
+	if r.Sign() < 0 {
+		return nil, fmt.Errorf("error in expression sqrt %v: negative argument", a)
+	}
+	num, numOK := exactSqrt(r.Num())
+	denom, denomOK := exactSqrt(r.Denom())
+	if !numOK || !denomOK {
+		return nil, fmt.Errorf("error in expression sqrt %v: square root isn't rational", a)
+	}
+	return normalize(new(big.Rat).SetFrac(num, denom)), nil
+}
+
+func (bigBackend) Cmp(a, b value) int {
+	return toRat(a).Cmp(toRat(b))
+}
+
+// exactSqrt gives square root of non-negative n if n is perfect square.
+func exactSqrt(n *big.Int) (*big.Int, bool) {
+	r := new(big.Int).Sqrt(n)
+	return r, new(big.Int).Mul(r, r).Cmp(n) == 0
+}
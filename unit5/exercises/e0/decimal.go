@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxDecimalScale limits digits after decimal point, results of multiplication are rounded to it.
+const maxDecimalScale = 18
+
+// decimal is fixed-point number units * 10^-scale, for example 12.50 is {1250, 2}.
+// It's integer with decimal point, so it has integer semantics: overflow and division
+// by zero are errors, not infinities.
+type decimal struct {
+	units int
+	scale int
+}
+
+func parseDecimal(s string) (decimal, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	units, err := strconv.Atoi(whole + frac)
+	if err != nil || len(frac) > maxDecimalScale {
+		return decimal{}, fmt.Errorf("argument %q: %w", s, ErrNotValidInteger)
+	}
+	return decimal{units: units, scale: len(frac)}, nil
+}
+
+func (d decimal) String() string {
+	s := strconv.Itoa(d.units)
+	sign := ""
+	if d.units < 0 {
+		sign, s = "-", s[1:]
+	}
+	if d.scale == 0 {
+		return sign + s
+	}
+	if len(s) <= d.scale {
+		s = strings.Repeat("0", d.scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]
+}
+
+func (d decimal) float() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// rescale adds zeros after decimal point, scale must not be less than d.scale.
+func (d decimal) rescale(scale int) (decimal, error) {
+	factor, err := pow(10, scale-d.scale)
+	if err != nil {
+		return decimal{}, err
+	}
+	units, err := mul(d.units, factor)
+	if err != nil {
+		return decimal{}, err
+	}
+	return decimal{units: units, scale: scale}, nil
+}
+
+// round rounds half away from zero to the scale less than d.scale.
+func (d decimal) round(scale int) decimal {
+	if d.scale-scale > maxDecimalScale {
+		return decimal{scale: scale} // any int is less than half of 10^19
+	}
+	factor, _ := pow(10, d.scale-scale) // 10^18 fits to int
+	return decimal{units: divRound(d.units, factor), scale: scale}
+}
+
+// divRound divides integers rounding half away from zero, b must not be zero.
+func divRound(a, b int) int {
+	q, r := a/b, a%b
+	absR, absB := r, b
+	if absR < 0 {
+		absR = -absR
+	}
+	if absB < 0 {
+		absB = -absB
+	}
+	if absR >= absB-absR { // 2*|r| >= |b| without overflow
+		if (a < 0) != (b < 0) {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+// align rescales both decimals to the larger scale.
+func align(a, b decimal) (decimal, decimal, error) {
+	scale := max(a.scale, b.scale)
+	a, err := a.rescale(scale)
+	if err != nil {
+		return a, b, err
+	}
+	b, err = b.rescale(scale)
+	return a, b, err
+}
+
+// decimalError rewrites error of units to error of decimals, so message shows numbers as they are.
+func decimalError(a decimal, op string, b decimal, err error) error {
+	for _, sentinel := range []error{ErrIntOverflow, ErrDivideByZero} {
+		if errors.Is(err, sentinel) {
+			return fmt.Errorf("error in expression %s %s %s: %w", a, op, b, sentinel)
+		}
+	}
+	return err
+}
+
+func addDecimal(a, b decimal) (decimal, error) {
+	x, y, err := align(a, b)
+	if err == nil {
+		x.units, err = add(x.units, y.units)
+	}
+	return x, decimalError(a, "+", b, err)
+}
+
+func subDecimal(a, b decimal) (decimal, error) {
+	x, y, err := align(a, b)
+	if err == nil {
+		x.units, err = sub(x.units, y.units)
+	}
+	return x, decimalError(a, "-", b, err)
+}
+
+func mulDecimal(a, b decimal) (decimal, error) {
+	units, err := mul(a.units, b.units)
+	if err != nil {
+		return decimal{}, decimalError(a, "*", b, err)
+	}
+	r := decimal{units: units, scale: a.scale + b.scale}
+	if r.scale > maxDecimalScale {
+		r = r.round(maxDecimalScale)
+	}
+	// trailing zeros aren't kept beyond scale of arguments: 1.0 * 1.0 is 1.0, but 1.5 * 1.5 is 2.25
+	for r.scale > max(a.scale, b.scale) && r.units%10 == 0 {
+		r.units /= 10
+		r.scale--
+	}
+	return r, nil
+}
+
+// divDecimal rounds result to the larger scale of arguments: 10.00 / 3 is 3.33. If minScale is larger,
+// result has more digits: output precision is applied to it, and padding 3.33 to 3.330 would show digit
+// which was never computed. The larger scale is given up if the quotient doesn't fit to int with it.
+func divDecimal(a, b decimal, minScale int) (decimal, error) {
+	if b.units == 0 {
+		return decimal{}, decimalError(a, "/", b, ErrDivideByZero)
+	}
+	// a / b = (a.units * 10^(scale + b.scale - a.scale) / b.units) * 10^-scale
+	scale := max(a.scale, b.scale)
+	if wide := min(minScale, maxDecimalScale); wide > scale {
+		if x, err := a.rescale(wide + b.scale); err == nil {
+			return decimal{units: divRound(x.units, b.units), scale: wide}, nil
+		}
+	}
+	x, err := a.rescale(scale + b.scale)
+	if err != nil {
+		return decimal{}, decimalError(a, "/", b, err)
+	}
+	return decimal{units: divRound(x.units, b.units), scale: scale}, nil
+}
+
+func modDecimal(a, b decimal) (decimal, error) {
+	x, y, err := align(a, b)
+	if err == nil {
+		x.units, err = mod(x.units, y.units)
+	}
+	return x, decimalError(a, "%", b, err)
+}
+
+// powDecimal raises decimal to non-negative integer power.
+func powDecimal(a decimal, n int) (decimal, error) {
+	// exponentiation by squaring like in pow
+	r, base := decimal{units: 1}, a
+	for k := n; k > 0; {
+		var err error
+		if k&1 == 1 {
+			r, err = mulDecimal(r, base)
+		}
+		k >>= 1
+		if err == nil && k > 0 {
+			base, err = mulDecimal(base, base)
+		}
+		if err != nil {
+			return decimal{}, fmt.Errorf("error in expression %s ^ %d: %w", a, n, ErrIntOverflow)
+		}
+	}
+	return r, nil
+}
+
+func negDecimal(a decimal) (decimal, error) {
+	units, err := neg(a.units)
+	if err != nil {
+		return decimal{}, fmt.Errorf("error in expression -(%s): %w", a, ErrIntOverflow)
+	}
+	return decimal{units: units, scale: a.scale}, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// binaryOp is operation of two arguments computed by numeric backend.
+type binaryOp func(b backend, x, y value) (value, error)
+
+// operators are infix operators and their functions.
+var operators = map[string]binaryOp{
+	"+": backend.Add,
+	"-": backend.Sub,
+	"*": backend.Mul,
+	"/": backend.Div,
+	"%": backend.Mod,
+	"^": backend.Pow,
+}
+
+// evaluator executes statements of the script. It keeps variables and results of previous lines.
+type evaluator struct {
+	num      backend
+	policies policies
+	ops      registry // built-in and user-defined operations
+	vars     map[string]value
+	results  []value // the last results of expression lines, nil is substituted result
+	forgot   int     // number of results before results[0], so $N is results[N-1-forgot]
+
+	locals map[string]value // arguments of the user-defined function which is computed
+	depth  int              // nesting of calls of user-defined functions
+}
+
+func newEvaluator(num backend) *evaluator {
+	return &evaluator{num: num, policies: policies{}, ops: maps.Clone(builtins), vars: map[string]value{}}
+}
+
+// exec executes statement and reports if its result is to be printed: results of let statements aren't.
+func (ev *evaluator) exec(stmt statement) (result value, print bool, err error) {
+	switch stmt := stmt.(type) {
+	case letStmt:
+		if len(ev.ops.arities(stmt.name)) > 0 {
+			return nil, false, errorAt(stmt.col, fmt.Errorf("variable %s: name is taken by operation", stmt.name))
+		}
+		v, err := ev.eval(stmt.x)
+		if err != nil {
+			return nil, false, err
+		}
+		ev.vars[stmt.name] = v
+		return v, false, nil
+
+	case exprStmt:
+		v, err := ev.eval(stmt.x)
+		if err != nil {
+			return nil, false, err
+		}
+		ev.addResult(v)
+		return v, true, nil
+
+	case defStmt:
+		if len(builtins.arities(stmt.name)) > 0 {
+			return nil, false, errorAt(stmt.col, fmt.Errorf("function %s: name is taken by built-in operation", stmt.name))
+		}
+		if _, ok := ev.vars[stmt.name]; ok {
+			return nil, false, errorAt(stmt.col, fmt.Errorf("function %s: name is taken by variable", stmt.name))
+		}
+		// function can be redefined, calls of it in other functions call the new one
+		ev.ops.register(stmt.name, len(stmt.params), userFunction(stmt))
+		return nil, false, nil
+
+	case policyStmt:
+		for err, p := range stmt.policies {
+			ev.policies[err] = p
+		}
+		return nil, false, nil
+	}
+	panic(fmt.Sprintf("unknown statement %T", stmt))
+}
+
+// eval computes expression with the backend, so overflow and division by zero are errors.
+// Errors are *PosError with column of the operation which failed.
+func (ev *evaluator) eval(e expr) (value, error) {
+	switch e := e.(type) {
+	case numberLit:
+		parse := func(b backend, _, _ value) (value, error) { return b.Parse(e.text) }
+		v, err := ev.apply(parse, nil, nil)
+		return v, errorAt(e.col, err)
+
+	case unaryExpr:
+		x, err := ev.eval(e.x)
+		if err != nil {
+			return nil, err
+		}
+		v, err := ev.apply(func(b backend, x, _ value) (value, error) { return b.Neg(x) }, x, nil)
+		return v, errorAt(e.col, err)
+
+	case binaryExpr:
+		x, err := ev.eval(e.x)
+		if err != nil {
+			return nil, err
+		}
+		y, err := ev.eval(e.y)
+		if err != nil {
+			return nil, err
+		}
+		v, err := ev.apply(operators[e.op], x, y)
+		return v, errorAt(e.col, err)
+
+	case resultRef:
+		total := ev.forgot + len(ev.results)
+		n := total // $_
+		if e.text != "$_" {
+			n, _ = strconv.Atoi(e.text[1:])
+		}
+		if n < 1 || n > total {
+			return nil, errorAt(e.col, fmt.Errorf("error in expression %s: %w", e.text, ErrUndefinedVariable))
+		}
+		if n <= ev.forgot {
+			return nil, errorAt(e.col, fmt.Errorf("error in expression %s: result is forgotten, only the last %d are kept: %w", e.text, len(ev.results), ErrUndefinedVariable))
+		}
+		v := ev.results[n-1-ev.forgot]
+		if v == nil {
+			return nil, errorAt(e.col, fmt.Errorf("error in expression %s: result is substituted: %w", e.text, ErrUndefinedVariable))
+		}
+		return v, nil
+
+	case callExpr:
+		if len(e.args) == 0 {
+			if v, ok := ev.locals[e.name]; ok {
+				return v, nil
+			}
+			if v, ok := ev.vars[e.name]; ok {
+				return v, nil
+			}
+		}
+
+		f, ok := ev.ops[opKey{name: e.name, arity: len(e.args)}]
+		if !ok {
+			arities := ev.ops.arities(e.name)
+			switch {
+			case len(arities) > 0:
+				return nil, errorAt(e.col, fmt.Errorf("instruction %s takes %s arguments, got %d", e.name, aritiesText(arities), len(e.args)))
+			case len(e.args) == 0:
+				return nil, errorAt(e.col, fmt.Errorf("error in expression %s: %w", e.name, ErrUndefinedVariable))
+			}
+			return nil, errorAt(e.col, fmt.Errorf("wrong instruction %s", e.name))
+		}
+
+		args := make([]value, len(e.args))
+		for i, arg := range e.args {
+			v, err := ev.eval(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		v, err := f(ev, args)
+		return v, errorAt(e.col, err)
+	}
+	panic(fmt.Sprintf("unknown expression %T", e))
+}
+
+// apply computes operation with the backend, its error is saturated if policy says so.
+func (ev *evaluator) apply(op binaryOp, x, y value) (value, error) {
+	v, err := op(ev.num, x, y)
+	if err != nil {
+		return ev.saturate(err, op, x, y)
+	}
+	return v, nil
+}
+
+// substituted records result of expression line which is printed as substitute token,
+// so $N still refers to the N-th line of output.
+func (ev *evaluator) substituted() {
+	ev.addResult(nil)
+}
+
+// maxResults limits number of results kept for $N, so endless stream doesn't take all the memory.
+const maxResults = 1 << 16
+
+func (ev *evaluator) addResult(v value) {
+	if len(ev.results) == maxResults {
+		// the older half is forgotten at once, so results aren't moved on every line
+		ev.results = slices.Clone(ev.results[maxResults/2:])
+		ev.forgot += maxResults / 2
+	}
+	ev.results = append(ev.results, v)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOperator // one of + - * / % ^ ( ) =
+	tokResult   // reference to previous result: $_ or $N
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	col  int // 1-based column of the first character in the line
+
+	// spaceBefore tells if the token is separated from the previous one,
+	// it makes "mul 2 -3" different from "mul 2 - 3"
+	spaceBefore bool
+}
+
+const operatorChars = "+-*/%^()="
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func skipDigits(s string, i int) int {
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+func isNumber(s string) bool {
+	for i := range len(s) {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || isDigit(c)
+}
+
+// lex splits line to tokens. Everything after # is a comment.
+// Errors are *PosError with column of the wrong token.
+func lex(line string) ([]token, error) {
+	tokens := []token{}
+	space := true
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			space = true
+			i++
+			continue
+		case c == '#':
+			i = len(line)
+			continue
+		case isDigit(c):
+			// digits [ "." digits ] [ ("e" | "E") [ "+" | "-" ] digits ]
+			i = skipDigits(line, i)
+			if i+1 < len(line) && line[i] == '.' && isDigit(line[i+1]) {
+				i = skipDigits(line, i+1)
+			}
+			if i < len(line) && (line[i] == 'e' || line[i] == 'E') {
+				j := i + 1
+				if j < len(line) && (line[j] == '+' || line[j] == '-') {
+					j++
+				}
+				if j < len(line) && isDigit(line[j]) {
+					i = skipDigits(line, j)
+				}
+			}
+			// something like 12abc or 1.2.3 is taken as one token, backend fails to parse it
+			// with ErrNotValidInteger, so error policy is applied to it like to too large number
+			for i < len(line) && (isIdentChar(line[i]) || line[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: line[start:i], col: start + 1, spaceBefore: space})
+		case c == '$':
+			i++
+			for i < len(line) && isIdentChar(line[i]) {
+				i++
+			}
+			ref := line[start:i]
+			if ref != "$_" && !isNumber(ref[1:]) {
+				return nil, errorAt(start+1, fmt.Errorf("result reference %q must be $_ or $N", ref))
+			}
+			tokens = append(tokens, token{kind: tokResult, text: ref, col: start + 1, spaceBefore: space})
+		case isIdentChar(c):
+			for i < len(line) && isIdentChar(line[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: line[start:i], col: start + 1, spaceBefore: space})
+		case strings.IndexByte(operatorChars, c) >= 0:
+			i++
+			tokens = append(tokens, token{kind: tokOperator, text: line[start:i], col: start + 1, spaceBefore: space})
+		default:
+			return nil, errorAt(start+1, fmt.Errorf("unexpected character %q", c))
+		}
+		space = false
+	}
+
+	return append(tokens, token{kind: tokEOF, col: len(line) + 1, spaceBefore: space}), nil
+}
@@ -3,20 +3,24 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
-	"strconv"
-	"strings"
 )
 
 var ErrDivideByZero = errors.New("divide by zero")
 var ErrIntOverflow = errors.New("integer overflow")
 var ErrNotValidInteger = errors.New("argument is not valid integer")
+var ErrUndefinedVariable = errors.New("undefined variable")
+var ErrRecursionLimit = errors.New("recursion limit exceeded")
 
 func mul(a, b int) (int, error) {
 	r := a * b
-	if r/a != b {
+	// a == -1 is checked separately: -1 * math.MinInt overflows, but r/a == b holds
+	if a != 0 && (r/a != b || a == -1 && b == math.MinInt) {
 		// here we wrapped error ErrIntOverflow with additional message "error in expression..." and also provided expression.
 		return 0, fmt.Errorf("error in expression %d * %d: %w", a, b, ErrIntOverflow)
 	}
@@ -28,70 +32,251 @@ func div(a, b int) (int, error) {
 		// here we wrapped error ErrDivideByZero with additional message "error in expression..." and also provided expression.
 		return 0, fmt.Errorf("error in expression %d / %d: %w", a, b, ErrDivideByZero)
 	}
+	if a == math.MinInt && b == -1 {
+		return 0, fmt.Errorf("error in expression %d / %d: %w", a, b, ErrIntOverflow)
+	}
 	return a / b, nil
 }
 
-type Operation struct {
-	F func(a, b int) (int, error)
-	A int
-	B int
+func add(a, b int) (int, error) {
+	r := a + b
+	// sum of numbers of the same sign can't have different sign
+	if (a > 0 && b > 0 && r < 0) || (a < 0 && b < 0 && r >= 0) {
+		return 0, fmt.Errorf("error in expression %d + %d: %w", a, b, ErrIntOverflow)
+	}
+	return r, nil
 }
 
-func parseInstructionsStdin() ([]Operation, error) {
-	if os.Stdin == nil {
-		return nil, fmt.Errorf("stdin is not provided")
+func sub(a, b int) (int, error) {
+	r := a - b
+	if (b < 0 && r < a) || (b > 0 && r > a) {
+		return 0, fmt.Errorf("error in expression %d - %d: %w", a, b, ErrIntOverflow)
 	}
+	return r, nil
+}
 
-	fileScanner := bufio.NewScanner(os.Stdin)
-	fileScanner.Split(bufio.ScanLines)
-
-	ops := []Operation{}
+func mod(a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("error in expression %d %% %d: %w", a, b, ErrDivideByZero)
+	}
+	if b == -1 {
+		return 0, nil // math.MinInt % -1 panics
+	}
+	return a % b, nil
+}
 
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		words := strings.Fields(line)
+func pow(a, b int) (int, error) {
+	if b < 0 {
+		return 0, fmt.Errorf("error in expression %d ^ %d: negative exponent", a, b)
+	}
 
-		a, err := strconv.Atoi(words[1])
+	// exponentiation by squaring: a^b is product of a^(2^k) for bits k set in b
+	r, base, n := 1, a, b
+	for n > 0 {
+		var err error
+		if n&1 == 1 {
+			r, err = mul(r, base)
+		}
+		n >>= 1
+		if err == nil && n > 0 {
+			// base is squared only if it's needed, so its overflow means overflow of the result
+			base, err = mul(base, base)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("wrong instruction format \"%s\", argument A: %w", line, ErrNotValidInteger)
+			return 0, fmt.Errorf("error in expression %d ^ %d: %w", a, b, ErrIntOverflow)
+		}
+	}
+	return r, nil
+}
+
+func neg(a int) (int, error) {
+	if a == math.MinInt {
+		return 0, fmt.Errorf("error in expression -(%d): %w", a, ErrIntOverflow)
+	}
+	return -a, nil
+}
+
+// errWrongFormat marks errors of parser, they are reported as wrong instruction format.
+var errWrongFormat = errors.New("wrong instruction format")
+
+// wrongFormatError is error of parser. It has the message of the wrapped *PosError and is errWrongFormat,
+// but it isn't joined error, so report doesn't split it.
+type wrongFormatError struct {
+	err error
+}
+
+func (e wrongFormatError) Error() string {
+	return e.err.Error()
+}
+
+func (e wrongFormatError) Unwrap() error {
+	return e.err
+}
+
+func (e wrongFormatError) Is(target error) bool {
+	return target == errWrongFormat
+}
+
+// execLine parses and computes one line of the script and writes its result to w.
+// Error of computation is handled by error policies. Error which isn't handled is *PosError,
+// error of parser is also errWrongFormat.
+func execLine(ev *evaluator, pos Pos, line string, w io.Writer) error {
+	stmt, err := parseLine(line)
+	if err != nil {
+		return wrongFormatError{err: atLine(err, pos, line)}
+	}
+	if stmt == nil {
+		return nil // empty line or comment
+	}
+
+	result, print, err := ev.exec(stmt)
+	if err != nil {
+		switch p := ev.policies.lookup(err); p.action {
+		case actionSkip:
+			return nil
+		case actionSubstitute:
+			if _, ok := stmt.(exprStmt); ok {
+				fmt.Fprintln(w, p.substitute)
+				ev.substituted()
+			}
+			return nil // there is nothing to substitute in let line, it's skipped
 		}
+		return atLine(err, pos, line)
+	}
+	if print {
+		fmt.Fprintln(w, ev.num.Format(result))
+	}
+	return nil
+}
+
+// process parses and computes script line by line as it's read from r, file is its name for error
+// messages. Result of every line is written to w and flushed at once, so the program can be filter
+// of endless stream. If collect is true, failed lines are skipped and errors of all of them are
+// returned joined by errors.Join, otherwise the first error stops the script.
+func process(ev *evaluator, file string, r io.Reader, w io.Writer, collect bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanLines)
+	out := bufio.NewWriter(w)
+	var errs []error
 
-		b, err := strconv.Atoi(words[2])
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		err := execLine(ev, Pos{File: file, Line: lineNo}, scanner.Text(), out)
+		if flushErr := out.Flush(); flushErr != nil {
+			return errors.Join(append(errs, flushErr)...) // output is closed, nobody reads results
+		}
 		if err != nil {
-			return nil, fmt.Errorf("wrong instruction format \"%s\", argument B: %w", line, ErrNotValidInteger)
+			if !collect {
+				return err
+			}
+			errs = append(errs, err)
 		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-		var op func(a, b int) (int, error)
+// Exit codes of error categories, they are used with -all flag only: without it any error exits with 1,
+// as it always has, so scripts checking for 1 keep working. Flag package exits with 2 on wrong flags,
+// so 2 isn't used.
+const (
+	exitError             = 1 // error which isn't of any category below
+	exitSyntax            = 3 // wrong instruction format
+	exitNotValidInteger   = 4
+	exitUndefinedVariable = 5
+	exitIntOverflow       = 6
+	exitDivideByZero      = 7
+	exitRecursionLimit    = 8
+)
+
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{ErrNotValidInteger, exitNotValidInteger},
+	{ErrUndefinedVariable, exitUndefinedVariable},
+	{ErrIntOverflow, exitIntOverflow},
+	{ErrDivideByZero, exitDivideByZero},
+	{ErrRecursionLimit, exitRecursionLimit},
+}
 
-		switch words[0] {
-		case "mul":
-			op = mul
-		case "div":
-			op = div
-		default:
-			return nil, fmt.Errorf("wrong instruction %s, on line %s", words[0], line)
+// exitCode gives exit code of category of error. Joined errors get code of the first one,
+// it's the first error in the script.
+func exitCode(err error) int {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return exitCode(joined.Unwrap()[0])
+	}
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
 		}
+	}
+	if errors.Is(err, errWrongFormat) {
+		return exitSyntax
+	}
+	return exitError
+}
 
-		ops = append(ops, Operation{
-			F: op,
-			A: a,
-			B: b,
-		})
+// report prints every error with its position to w, joined errors are printed one by one.
+func report(w io.Writer, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			report(w, err)
+		}
+		return
 	}
-	return ops, nil
+	prefix := "Computation error"
+	if errors.Is(err, errWrongFormat) {
+		prefix = "Wrong instruction format"
+	}
+	fmt.Fprintf(w, "%s: %s\n", prefix, render(err))
 }
 
 func main() {
-	ops, err := parseInstructionsStdin()
-	if err != nil {
-		log.Fatalln(err)
+	bigint := flag.Bool("bigint", false, "compute with arbitrary-precision integers and exact fractions instead of int")
+	precision := flag.Int("precision", -1, "digits after decimal point in output of non-integer results, negative means as computed")
+	all := flag.Bool("all", false, "don't stop at the first error, report errors of all lines, exit code tells category of the first one")
+	onError := policies{}
+	flag.Var(onError, "on-error", "error policy NAME=ACTION, NAME is divzero, overflow or invalid, ACTION is abort, skip, substitute:TOKEN or saturate; can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [script]\nScript is read from stdin if file isn't given, if stdin is terminal lines are computed as they are typed.\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	// errors are printed with position in the script, timestamp says nothing about them
+	log.SetFlags(0)
+
+	var num backend = nativeBackend{precision: *precision}
+	if *bigint {
+		num = bigBackend{precision: *precision}
 	}
 
-	for _, op := range ops {
-		result, err := op.F(op.A, op.B)
+	ev := newEvaluator(num)
+	ev.policies = onError
+
+	if flag.Arg(0) == "" && interactive() {
+		if err := startREPL(ev); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	in, file := io.Reader(os.Stdin), "<stdin>"
+	if name := flag.Arg(0); name != "" {
+		f, err := os.Open(name)
 		if err != nil {
-			log.Fatalf("Computation error: %v\n", err)
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		in, file = f, name
+	}
+
+	if err := process(ev, file, in, os.Stdout, *all); err != nil {
+		report(os.Stderr, err)
+		if *all {
+			os.Exit(exitCode(err))
 		}
-		fmt.Println(result)
+		os.Exit(exitError)
 	}
 }
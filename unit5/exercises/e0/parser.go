@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// expr is node of abstract syntax tree of the expression.
+type expr interface {
+	exprNode()
+}
+
+// numberLit is number as it's written in the script, it's converted to the number when evaluated.
+type numberLit struct {
+	text string
+	col  int
+}
+
+type unaryExpr struct {
+	op  string
+	col int // column of the operator
+	x   expr
+}
+
+type binaryExpr struct {
+	op   string
+	col  int // column of the operator
+	x, y expr
+}
+
+// callExpr is call of named operation, for example "mul 2 3".
+// Name without arguments is variable if it's defined.
+type callExpr struct {
+	name string
+	col  int
+	args []expr
+}
+
+// resultRef is reference to previous result: $_ is the last one, $1 is the first one.
+type resultRef struct {
+	text string
+	col  int
+}
+
+func (numberLit) exprNode()  {}
+func (unaryExpr) exprNode()  {}
+func (binaryExpr) exprNode() {}
+func (callExpr) exprNode()   {}
+func (resultRef) exprNode()  {}
+
+// statement is line of the script.
+type statement interface {
+	stmtNode()
+}
+
+// exprStmt is expression which result is printed.
+type exprStmt struct {
+	x expr
+}
+
+// letStmt assigns result of expression to variable: let x = mul 3 4
+type letStmt struct {
+	name string
+	col  int // column of the name
+	x    expr
+}
+
+// defStmt defines function: def sq x = mul x x
+type defStmt struct {
+	name   string
+	col    int // column of the name
+	params []string
+	body   expr
+}
+
+// policyStmt is directive line which sets error policies for the next lines: #on-error divzero=skip
+type policyStmt struct {
+	policies policies
+}
+
+func (exprStmt) stmtNode()   {}
+func (letStmt) stmtNode()    {}
+func (defStmt) stmtNode()    {}
+func (policyStmt) stmtNode() {}
+
+// parser is recursive descent parser of the grammar:
+//
+//	line    = "let" IDENT "=" expr | "def" IDENT { IDENT } "=" expr | expr | DIRECTIVE
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/" | "%") unary }
+//	unary   = "-" unary | power
+//	power   = primary [ "^" unary ]
+//	primary = NUMBER | RESULT | "(" expr ")" | IDENT { atom }
+//	atom    = NUMBER | "-" NUMBER | RESULT | "(" expr ")" | IDENT
+//
+// So "^" binds tighter than unary minus and is right-associative: -2^2 is -4 and 2^3^2 is 512.
+// Operation takes arguments written after its name, like in the old "mul 2 3" format.
+// Negative number argument must be written without space after minus: "mul 2 -3".
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseLine returns nil statement for empty line or line with only a comment.
+// Errors are *PosError with column where the line is wrong.
+func parseLine(line string) (statement, error) {
+	if stmt, ok, err := parseDirective(line); ok {
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+
+	tokens, err := lex(line)
+	if err != nil {
+		return nil, err
+	}
+	if tokens[0].kind == tokEOF {
+		return nil, nil
+	}
+
+	p := &parser{tokens: tokens}
+	var stmt statement
+	if t := p.peek(); t.kind == tokIdent && t.text == "let" {
+		stmt, err = p.let()
+	} else if t.kind == tokIdent && t.text == "def" {
+		stmt, err = p.def()
+	} else {
+		var e expr
+		e, err = p.expr()
+		stmt = exprStmt{x: e}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorAt(t.col, fmt.Errorf("unexpected %q", t.text))
+	}
+	return stmt, nil
+}
+
+func (p *parser) let() (statement, error) {
+	p.next() // let
+	name := p.next()
+	if !isName(name) {
+		return nil, errorAt(name.col, errors.New("expected variable name"))
+	}
+	if !p.isOperator("=") {
+		return nil, errorAt(p.peek().col, errors.New(`expected "="`))
+	}
+	p.next()
+
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return letStmt{name: name.text, col: name.col, x: e}, nil
+}
+
+func (p *parser) def() (statement, error) {
+	p.next() // def
+	name := p.next()
+	if !isName(name) {
+		return nil, errorAt(name.col, errors.New("expected function name"))
+	}
+
+	def := defStmt{name: name.text, col: name.col}
+	for p.peek().kind == tokIdent {
+		param := p.next()
+		if !isName(param) || slices.Contains(def.params, param.text) {
+			return nil, errorAt(param.col, fmt.Errorf("wrong parameter %s", param.text))
+		}
+		def.params = append(def.params, param.text)
+	}
+	if !p.isOperator("=") {
+		return nil, errorAt(p.peek().col, errors.New(`expected parameter name or "="`))
+	}
+	p.next()
+
+	body, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	def.body = body
+	return def, nil
+}
+
+// isName tells if token can be name of variable or function, keywords can't.
+func isName(t token) bool {
+	return t.kind == tokIdent && t.text != "let" && t.text != "def"
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOperator(ops ...string) bool {
+	return p.isOperatorAt(p.pos, ops...)
+}
+
+func (p *parser) isOperatorAt(pos int, ops ...string) bool {
+	t := p.tokens[min(pos, len(p.tokens)-1)]
+	if t.kind != tokOperator {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expr() (expr, error) {
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("+", "-") {
+		op := p.next()
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: op.text, col: op.col, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) term() (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("*", "/", "%") {
+		op := p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: op.text, col: op.col, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.isOperator("-") {
+		minus := p.next()
+		if t := p.peek(); t.kind == tokNumber && !p.isOperatorAt(p.pos+1, "^") {
+			// negative literal, so the most negative integer can be written
+			p.next()
+			return numberLit{text: "-" + t.text, col: minus.col}, nil
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: "-", col: minus.col, x: x}, nil
+	}
+	return p.power()
+}
+
+func (p *parser) power() (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOperator("^") {
+		op := p.next()
+		y, err := p.unary() // not power: 2^-1 is allowed
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{op: "^", col: op.col, x: x, y: y}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		return numberLit{text: t.text, col: t.col}, nil
+	case t.kind == tokResult:
+		p.next()
+		return resultRef{text: t.text, col: t.col}, nil
+	case t.kind == tokIdent:
+		p.next()
+		call := callExpr{name: t.text, col: t.col}
+		for p.atAtom() {
+			arg, err := p.atom()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		return call, nil
+	case p.isOperator("("):
+		return p.paren()
+	case t.kind == tokEOF:
+		return nil, errorAt(t.col, errors.New("unexpected end of line"))
+	}
+	return nil, errorAt(t.col, fmt.Errorf("unexpected %q", t.text))
+}
+
+// atAtom tells if the next token starts argument of operation.
+func (p *parser) atAtom() bool {
+	t := p.peek()
+	switch {
+	case t.kind == tokNumber || t.kind == tokResult || t.kind == tokIdent || p.isOperator("("):
+		return true
+	case p.isOperator("-"):
+		// "-3" after space is negative argument, "- 3" or "2-3" is subtraction
+		after := p.tokens[p.pos+1]
+		return t.spaceBefore && after.kind == tokNumber && !after.spaceBefore
+	}
+	return false
+}
+
+func (p *parser) atom() (expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		return numberLit{text: t.text, col: t.col}, nil
+	case t.kind == tokResult:
+		return resultRef{text: t.text, col: t.col}, nil
+	case t.kind == tokIdent:
+		return callExpr{name: t.text, col: t.col}, nil
+	case t.kind == tokOperator && t.text == "-":
+		return numberLit{text: "-" + p.next().text, col: t.col}, nil
+	}
+	p.pos--
+	return p.paren()
+}
+
+func (p *parser) paren() (expr, error) {
+	p.next() // (
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOperator(")") {
+		t := p.peek()
+		return nil, errorAt(t.col, errors.New(`expected ")"`))
+	}
+	p.next()
+	return e, nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalExpressions(t *testing.T) {
+	tests := []struct {
+		line string
+		want int
+	}{
+		{"mul 2 2", 4},
+		{"div 20 2", 10},
+		{"mul 10 3 # comment", 30},
+		{"2 + 3 * 4", 14},
+		{"(2 + 3) * 4", 20},
+		{"10 - 4 - 3", 3},
+		{"7 % 3 + 1", 2},
+		{"-2^2", -4},
+		{"(-2)^2", 4},
+		{"2^3^2", 512},
+		{"2^0", 1},
+		{"- -3", 3},
+		{"mul 2 -3", -6},
+		{"mul 2 3 - 1", 5},
+		{"mul (1 + 1) (2 * 3)", 12},
+		{"add 1 2 * 3", 9},
+		{"-9223372036854775808 / 1", -9223372036854775808},
+		{"mul 0 5", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			stmt, err := parseLine(tt.line)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, _, err := newEvaluator(nativeBackend{precision: -1}).exec(stmt)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want error
+	}{
+		{"9223372036854775807 + 1", ErrIntOverflow},
+		{"-9223372036854775807 - 2", ErrIntOverflow},
+		{"mul 10000000000 10000000000", ErrIntOverflow},
+		{"-(-9223372036854775808)", ErrIntOverflow},
+		{"-9223372036854775808 / -1", ErrIntOverflow},
+		{"pow 999 1000", ErrIntOverflow},
+		{"1 + 10 / (5 - 5)", ErrDivideByZero},
+		{"mod 1 0", ErrDivideByZero},
+		{"mul 99999999999999999999 1", ErrNotValidInteger},
+		{"12abc", ErrNotValidInteger},
+		{"mul 1.2.3 2", ErrNotValidInteger},
+		{"1.5x + 1", ErrNotValidInteger},
+		{"1e", ErrNotValidInteger},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			stmt, err := parseLine(tt.line)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, _, err = newEvaluator(nativeBackend{precision: -1}).exec(stmt)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{"2 +", "(1 + 2", "1 2", "2 * * 3", "1 & 2", ")", "#on-error divzero=retry", "#on-error foo=skip"} {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+
+	stmt, err := parseLine("   # only comment")
+	assert.NoError(t, err)
+	assert.Nil(t, stmt)
+}
+
+func TestVariablesAndResults(t *testing.T) {
+	script := []struct {
+		line  string
+		want  int
+		print bool
+	}{
+		{"let x = mul 3 4", 12, false},
+		{"x + 1", 13, true},
+		{"let y = x * $_", 156, false},
+		{"mul y 2", 312, true},
+		{"$1 + $2 + $_", 637, true},
+		{"let x = x - 2", 10, false},
+		{"(x)", 10, true},
+	}
+
+	ev := newEvaluator(nativeBackend{precision: -1})
+	for _, step := range script {
+		stmt, err := parseLine(step.line)
+		if !assert.NoError(t, err, step.line) {
+			return
+		}
+		got, print, err := ev.exec(stmt)
+		assert.NoError(t, err, step.line)
+		assert.Equal(t, step.want, got, step.line)
+		assert.Equal(t, step.print, print, step.line)
+	}
+
+	for _, line := range []string{"z + 1", "mul z 2", "$5", "$0", "let w = $_ + q"} {
+		stmt, err := parseLine(line)
+		if !assert.NoError(t, err, line) {
+			continue
+		}
+		_, _, err = ev.exec(stmt)
+		assert.ErrorIs(t, err, ErrUndefinedVariable, line)
+	}
+
+	for _, line := range []string{"let = 1", "let x 1", "let 1 = 2", "$x", "$"} {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		line string
+		col  int
+		want error
+	}{
+		{"2 + (3", 7, nil},
+		{"1 & 2", 3, nil},
+		{"mul 2 12abc", 7, ErrNotValidInteger},
+		{"let 1 = 2", 5, nil},
+		{"1 + 9223372036854775807 * 2", 25, ErrIntOverflow},
+		{"mul 2 (div 1 0)", 8, ErrDivideByZero},
+		{"-(-9223372036854775808)", 1, ErrIntOverflow},
+		{"add 1 99999999999999999999", 7, ErrNotValidInteger},
+		{"2 * foo", 5, ErrUndefinedVariable},
+		{"1 + $3", 5, ErrUndefinedVariable},
+		{"1 + sqr 2 3", 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			script := "mul 2 2\n\n" + tt.line + "\n"
+			err := process(newEvaluator(nativeBackend{precision: -1}), "test.txt", strings.NewReader(script), io.Discard, false)
+
+			var pe *PosError
+			if !assert.ErrorAs(t, err, &pe) {
+				return
+			}
+			assert.Equal(t, Pos{File: "test.txt", Line: 3, Col: tt.col}, pe.Pos)
+			assert.Equal(t, tt.line, pe.Source)
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	err := process(newEvaluator(nativeBackend{precision: -1}), "test.txt", strings.NewReader("\tmul 5 (div 1 0)"), io.Discard, false)
+	assert.ErrorIs(t, err, ErrDivideByZero)
+	assert.Equal(t, "test.txt:1:9: error in expression 1 / 0: divide by zero", err.Error())
+	assert.Equal(t, "test.txt:1:9: error in expression 1 / 0: divide by zero\n\tmul 5 (div 1 0)\n\t       ^", render(err))
+
+	err = process(newEvaluator(nativeBackend{precision: -1}), "test.txt", strings.NewReader("1 &"), io.Discard, false)
+	assert.ErrorIs(t, err, errWrongFormat)
+	assert.Equal(t, "test.txt:1:3: unexpected character '&'\n1 &\n  ^", render(err))
+}
+
+func TestCollectErrors(t *testing.T) {
+	script := "mul 2 2\n" +
+		"div 1 0\n" +
+		"1 & 2\n" +
+		"let x = 9223372036854775807 + 1\n" +
+		"add 1 2\n" +
+		"x * 2\n"
+
+	var out strings.Builder
+	err := process(newEvaluator(nativeBackend{precision: -1}), "test.txt", strings.NewReader(script), &out, true)
+	assert.Equal(t, "4\n3\n", out.String())
+	assert.ErrorIs(t, err, ErrDivideByZero)
+	assert.ErrorIs(t, err, errWrongFormat)
+	assert.ErrorIs(t, err, ErrIntOverflow)
+	assert.ErrorIs(t, err, ErrUndefinedVariable)
+	assert.Equal(t, exitDivideByZero, exitCode(err))
+
+	lines := []int{}
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var pe *PosError
+		if assert.ErrorAs(t, err, &pe) {
+			lines = append(lines, pe.Pos.Line)
+		}
+	}
+	assert.Equal(t, []int{2, 3, 4, 6}, lines)
+
+	// without collect the first error stops
+	out.Reset()
+	err = process(newEvaluator(nativeBackend{precision: -1}), "test.txt", strings.NewReader(script), &out, false)
+	var pe *PosError
+	assert.ErrorAs(t, err, &pe)
+	assert.Equal(t, 2, pe.Pos.Line)
+	assert.Equal(t, "4\n", out.String())
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, exitNotValidInteger, exitCode(errorAt(1, ErrNotValidInteger)))
+	assert.Equal(t, exitIntOverflow, exitCode(fmt.Errorf("x: %w", ErrIntOverflow)))
+	assert.Equal(t, exitSyntax, exitCode(fmt.Errorf("%w: %w", errWrongFormat, errorAt(1, errors.New("unexpected")))))
+	assert.Equal(t, exitError, exitCode(errors.New("wrong instruction foo")))
+	assert.Equal(t, exitUndefinedVariable, exitCode(errors.Join(ErrUndefinedVariable, ErrDivideByZero)))
+}
+
+func TestStreaming(t *testing.T) {
+	in, script := io.Pipe()
+	results, out := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- process(newEvaluator(nativeBackend{precision: -1}), "<stdin>", in, out, false)
+		out.Close()
+	}()
+
+	// every result is read before the next line is written, so nothing waits for the end of input
+	lines := bufio.NewScanner(results)
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(script, "let x = %d\nx * x\n", i)
+		if !assert.True(t, lines.Scan()) {
+			return
+		}
+		assert.Equal(t, strconv.Itoa(i*i), lines.Text())
+	}
+	script.Close()
+	assert.NoError(t, <-done)
+	assert.False(t, lines.Scan())
+}
+
+func TestForgottenResults(t *testing.T) {
+	ev := newEvaluator(nativeBackend{precision: -1})
+	var script strings.Builder
+	for i := 1; i <= maxResults+10; i++ {
+		fmt.Fprintf(&script, "%d\n", i)
+	}
+	assert.NoError(t, process(ev, "test.txt", strings.NewReader(script.String()), io.Discard, false))
+	assert.LessOrEqual(t, len(ev.results), maxResults)
+
+	// every line adds result, so $_ goes first
+	for _, step := range []struct {
+		line string
+		want int
+	}{
+		{"$_", maxResults + 10},
+		{"$" + strconv.Itoa(maxResults), maxResults},
+	} {
+		stmt, err := parseLine(step.line)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		got, _, err := ev.exec(stmt)
+		assert.NoError(t, err, step.line)
+		assert.Equal(t, step.want, got, step.line)
+	}
+
+	stmt, err := parseLine("$1")
+	if assert.NoError(t, err) {
+		_, _, err = ev.exec(stmt)
+		assert.ErrorIs(t, err, ErrUndefinedVariable)
+		assert.ErrorContains(t, err, "forgotten")
+	}
+}
+
+func TestReportSyntaxErrors(t *testing.T) {
+	script := "mul (1 2\n1 / 0\n"
+	for _, collect := range []bool{false, true} {
+		err := process(newEvaluator(nativeBackend{precision: -1}), "test.txt", strings.NewReader(script), io.Discard, collect)
+		assert.Equal(t, exitSyntax, exitCode(err))
+
+		var out strings.Builder
+		report(&out, err)
+		want := "Wrong instruction format: test.txt:1:8: expected \")\"\nmul (1 2\n       ^\n"
+		if collect {
+			want += "Computation error: test.txt:2:3: error in expression 1 / 0: divide by zero\n1 / 0\n  ^\n"
+		}
+		assert.Equal(t, want, out.String())
+	}
+}
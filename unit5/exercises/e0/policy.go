@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// action is what to do with line which failed to compute.
+type action int
+
+const (
+	actionAbort      action = iota // stop the script, it's default
+	actionSkip                     // skip the line as if there were no line
+	actionSubstitute               // print substitute token instead of the result
+	actionSaturate                 // take the largest or the smallest int instead of the result of operation
+)
+
+var actionNames = map[string]action{
+	"abort":      actionAbort,
+	"skip":       actionSkip,
+	"substitute": actionSubstitute,
+	"saturate":   actionSaturate,
+}
+
+type policy struct {
+	action     action
+	substitute string // token printed by actionSubstitute
+}
+
+func (p policy) String() string {
+	for name, a := range actionNames {
+		if a == p.action {
+			if a == actionSubstitute {
+				return name + ":" + p.substitute
+			}
+			return name
+		}
+	}
+	return strconv.Itoa(int(p.action))
+}
+
+// policyErrors are errors which can have policy, other errors always abort.
+var policyErrors = map[string]error{
+	"divzero":  ErrDivideByZero,
+	"overflow": ErrIntOverflow,
+	"invalid":  ErrNotValidInteger,
+}
+
+// policies maps error to its policy, error which isn't in the map aborts.
+// It's flag.Value which takes policies like "divzero=substitute:eternity".
+type policies map[error]policy
+
+// Set parses policy NAME=ACTION, where substitute action has the token after colon.
+func (ps policies) Set(spec string) error {
+	name, act, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("policy %q must be NAME=ACTION", spec)
+	}
+	err, ok := policyErrors[name]
+	if !ok {
+		return fmt.Errorf("policy %q: unknown error %q, it must be divzero, overflow or invalid", spec, name)
+	}
+	act, token, hasToken := strings.Cut(act, ":")
+	a, ok := actionNames[act]
+	if !ok {
+		return fmt.Errorf("policy %q: unknown action %q, it must be abort, skip, substitute or saturate", spec, act)
+	}
+	if (a == actionSubstitute) != hasToken || a == actionSubstitute && token == "" {
+		return fmt.Errorf("policy %q: substitute action takes token after colon, others don't", spec)
+	}
+	ps[err] = policy{action: a, substitute: token}
+	return nil
+}
+
+func (ps policies) String() string {
+	specs := []string{}
+	for name, err := range policyErrors {
+		if p, ok := ps[err]; ok {
+			specs = append(specs, name+"="+p.String())
+		}
+	}
+	sort.Strings(specs)
+	return strings.Join(specs, " ")
+}
+
+// lookup gives policy of error, it's abort for errors without policy.
+func (ps policies) lookup(err error) policy {
+	for e, p := range ps {
+		if errors.Is(err, e) {
+			return p
+		}
+	}
+	return policy{action: actionAbort}
+}
+
+// directive is prefix of the script line which sets policies: "#on-error divzero=skip overflow=saturate".
+// It's a comment for older versions of the program.
+const directive = "#on-error"
+
+// parseDirective parses policies of directive line, ok is false if the line isn't directive.
+// Errors are *PosError with column of the wrong policy.
+func parseDirective(line string) (stmt policyStmt, ok bool, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != directive {
+		return policyStmt{}, false, nil
+	}
+
+	stmt = policyStmt{policies: policies{}}
+	col := strings.Index(line, directive) + len(directive)
+	for _, spec := range fields[1:] {
+		col += strings.Index(line[col:], spec)
+		if err := stmt.policies.Set(spec); err != nil {
+			return policyStmt{}, true, errorAt(col+1, err)
+		}
+	}
+	return stmt, true, nil
+}
+
+// saturate applies saturate policy to error of operation op: the result is the largest or the smallest
+// int by sign of the exact result, which is computed again by bigBackend. Division by zero takes sign
+// of the dividend. Error is returned as it is if it has another policy or exact result can't be computed,
+// for example literal isn't number at all.
+func (ev *evaluator) saturate(err error, op binaryOp, x, y value) (value, error) {
+	if ev.policies.lookup(err).action != actionSaturate {
+		return nil, err
+	}
+
+	var sign int
+	if errors.Is(err, ErrDivideByZero) {
+		sign = toRat(exactValue(x)).Sign()
+	} else {
+		r, exactErr := op(bigBackend{}, exactValue(x), exactValue(y))
+		if exactErr != nil {
+			return nil, err
+		}
+		sign = toRat(r).Sign()
+	}
+
+	switch {
+	case sign > 0:
+		return ev.num.Parse(strconv.Itoa(math.MaxInt))
+	case sign < 0:
+		return ev.num.Parse(strconv.Itoa(math.MinInt))
+	}
+	return ev.num.Parse("0")
+}
+
+// exactValue converts value of nativeBackend to value of bigBackend. Floats don't fail,
+// so they never come here.
+func exactValue(v value) value {
+	switch v := v.(type) {
+	case int:
+		return big.NewInt(int64(v))
+	case decimal:
+		r, _ := new(big.Rat).SetString(v.String())
+		return normalize(r)
+	}
+	return v // already value of bigBackend or nil argument of unary operation
+}
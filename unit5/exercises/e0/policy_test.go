@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runScript runs script with the policies set before it and gives its output.
+func runScript(num backend, specs []string, script string) (string, error) {
+	ev := newEvaluator(num)
+	for _, spec := range specs {
+		if err := ev.policies.Set(spec); err != nil {
+			return "", err
+		}
+	}
+	var out strings.Builder
+	err := process(ev, "test.txt", strings.NewReader(script), &out, false)
+	return out.String(), err
+}
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		specs  []string
+		script string
+		want   string
+		err    error
+	}{
+		{"abort by default", nil, "div 10 0\nmul 2 2", "", ErrDivideByZero},
+		{"explicit abort", []string{"divzero=abort"}, "mul 2 2\ndiv 10 0", "4\n", ErrDivideByZero},
+		{"skip", []string{"divzero=skip"}, "div 10 0\nmul 2 2", "4\n", nil},
+		{"substitute", []string{"divzero=substitute:eternity"}, "div 10 0\nmul 2 2", "eternity\n4\n", nil},
+		{"substitute in let", []string{"divzero=substitute:eternity"}, "let x = 1 / 0\n2", "2\n", nil},
+		{"other error aborts", []string{"divzero=skip"}, "div 10 0\nmul 9223372036854775807 2", "", ErrIntOverflow},
+		{"saturate overflow", []string{"overflow=saturate"}, "mul 9223372036854775807 2\n-9223372036854775807 - 5\n(-3) ^ 41", "9223372036854775807\n-9223372036854775808\n-9223372036854775808\n", nil},
+		{"saturate inside expression", []string{"overflow=saturate"}, "(9223372036854775807 + 1) - 7", "9223372036854775800\n", nil},
+		{"saturate negation", []string{"overflow=saturate"}, "-(-9223372036854775808)", "9223372036854775807\n", nil},
+		{"saturate division by zero", []string{"divzero=saturate"}, "div 10 0\ndiv -10 0\ndiv 0 0", "9223372036854775807\n-9223372036854775808\n0\n", nil},
+		{"saturate decimal", []string{"overflow=saturate"}, "9223372036854775.807 * -10000", "-9223372036854775808\n", nil},
+		{"saturate large literal", []string{"invalid=saturate"}, "add -99999999999999999999 1", "-9223372036854775807\n", nil},
+		{"malformed literal isn't saturated", []string{"invalid=saturate"}, "add 12abc 1", "", ErrNotValidInteger},
+		{"skip malformed literal", []string{"invalid=skip"}, "add 12abc 1\n1", "1\n", nil},
+		{"directive", nil, "div 1 0 # abort\n", "", ErrDivideByZero},
+		{"directive changes policy", nil, "#on-error divzero=substitute:inf\ndiv 1 0\n#on-error divzero=abort\ndiv 1 0", "inf\n", ErrDivideByZero},
+		{"directive overrides flag", []string{"divzero=skip"}, "  #on-error divzero=substitute:x overflow=skip\ndiv 1 0\npow 9 99", "x\n", nil},
+		{"substituted result", []string{"divzero=substitute:eternity"}, "1 / 0\n$1 + 1", "eternity\n", ErrUndefinedVariable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runScript(nativeBackend{precision: -1}, tt.specs, tt.script)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPolicySpecs(t *testing.T) {
+	ps := policies{}
+	for _, spec := range []string{"divzero", "divzero=", "zero=skip", "divzero=retry", "divzero=substitute", "divzero=substitute:", "divzero=skip:x"} {
+		assert.Error(t, ps.Set(spec), spec)
+	}
+	assert.Empty(t, ps)
+
+	assert.NoError(t, ps.Set("overflow=saturate"))
+	assert.NoError(t, ps.Set("divzero=substitute:eternity"))
+	assert.Equal(t, "divzero=substitute:eternity overflow=saturate", ps.String())
+
+	_, err := runScript(nativeBackend{precision: -1}, nil, "mul 1 2\n#on-error divzero=skip invalid=x")
+	var pe *PosError
+	if assert.ErrorAs(t, err, &pe) {
+		assert.Equal(t, Pos{File: "test.txt", Line: 2, Col: 24}, pe.Pos)
+	}
+}
+
+func TestPolicyBigBackend(t *testing.T) {
+	got, err := runScript(bigBackend{precision: -1}, []string{"divzero=saturate"}, "div -7 0\n1 / 3")
+	assert.NoError(t, err)
+	assert.Equal(t, "-9223372036854775808\n1/3\n", got)
+
+	_, err = runScript(bigBackend{precision: -1}, []string{"overflow=saturate"}, "pow 10 100000000")
+	assert.ErrorIs(t, err, ErrIntOverflow)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pos is position in the script. Line and Col are 1-based, zero means unknown.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	s := p.File
+	if p.Line > 0 {
+		s += fmt.Sprintf(":%d", p.Line)
+		if p.Col > 0 {
+			s += fmt.Sprintf(":%d", p.Col)
+		}
+	}
+	return s
+}
+
+// PosError is error of parser or evaluator at position in the script. It wraps the original error,
+// so errors.Is(err, ErrIntOverflow) works as well as errors.As(err, &posErr) to get the position.
+type PosError struct {
+	Pos    Pos
+	Source string // text of the line, it's printed by Render
+	Err    error
+}
+
+func (e *PosError) Error() string {
+	if s := e.Pos.String(); s != "" {
+		return s + ": " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+func (e *PosError) Unwrap() error {
+	return e.Err
+}
+
+// Render formats error like compiler does, with the line and caret under the offending token:
+//
+//	instructions.txt:3:5: error in expression 10000000000 * 10000000000: integer overflow
+//	mul 10000000000 10000000000
+//	^
+func (e *PosError) Render() string {
+	if e.Source == "" || e.Pos.Col < 1 || e.Pos.Col > len(e.Source)+1 {
+		return e.Error()
+	}
+	// tabs are kept, so caret is under the token whatever tab width is
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, e.Source[:e.Pos.Col-1])
+	return e.Error() + "\n" + e.Source + "\n" + indent + "^"
+}
+
+// errorAt binds error to column of the line, line itself is set by atLine. Nil error stays nil.
+func errorAt(col int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PosError{Pos: Pos{Col: col}, Err: err}
+}
+
+// atLine binds error of the line to its position in the script. Column is taken from
+// PosError returned by parser or evaluator if there is one.
+func atLine(err error, pos Pos, source string) error {
+	var pe *PosError
+	if errors.As(err, &pe) {
+		pos.Col = pe.Pos.Col
+		err = pe.Err
+	}
+	return &PosError{Pos: pos, Source: source, Err: err}
+}
+
+// render formats error with Render if it has position.
+func render(err error) string {
+	var pe *PosError
+	if errors.As(err, &pe) {
+		return pe.Render()
+	}
+	return err.Error()
+}
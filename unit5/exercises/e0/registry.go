@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxCallDepth limits nesting of calls of user-defined functions. Functions have no conditions,
+// so recursive function never stops by itself.
+const maxCallDepth = 1000
+
+// opKey identifies operation: the same name can be registered with different number of arguments.
+type opKey struct {
+	name  string
+	arity int
+}
+
+// function is operation called by name, args has as many values as its arity.
+type function func(ev *evaluator, args []value) (value, error)
+
+// registry is table of operations which can be called by name.
+type registry map[opKey]function
+
+func (r registry) register(name string, arity int, f function) {
+	r[opKey{name: name, arity: arity}] = f
+}
+
+// arities gives numbers of arguments the name is registered with, in ascending order.
+func (r registry) arities(name string) []int {
+	arities := []int{}
+	for key := range r {
+		if key.name == name {
+			arities = append(arities, key.arity)
+		}
+	}
+	slices.Sort(arities)
+	return arities
+}
+
+// builtins are operations every script has, user-defined functions are added to copy of it.
+var builtins = newBuiltins()
+
+func newBuiltins() registry {
+	r := registry{}
+	r.register("add", 2, builtin(backend.Add))
+	r.register("sub", 2, builtin(backend.Sub))
+	r.register("mul", 2, builtin(backend.Mul))
+	r.register("div", 2, builtin(backend.Div))
+	r.register("mod", 2, builtin(backend.Mod))
+	r.register("pow", 2, builtin(backend.Pow))
+	r.register("gcd", 2, builtin(gcd))
+	r.register("lcm", 2, builtin(lcm))
+	r.register("min", 2, builtin(minOp))
+	r.register("max", 2, builtin(maxOp))
+	r.register("abs", 1, builtin(unary(abs)))
+	r.register("sqrt", 1, builtin(unary(backend.Sqrt)))
+	return r
+}
+
+// builtin makes function of operation computed by backend, so error policies are applied to it.
+func builtin(op binaryOp) function {
+	return func(ev *evaluator, args []value) (value, error) {
+		var x, y value
+		if len(args) > 0 {
+			x = args[0]
+		}
+		if len(args) > 1 {
+			y = args[1]
+		}
+		return ev.apply(op, x, y)
+	}
+}
+
+// unary makes binaryOp of operation of one argument, the second one is ignored.
+func unary(f func(b backend, x value) (value, error)) binaryOp {
+	return func(b backend, x, _ value) (value, error) {
+		return f(b, x)
+	}
+}
+
+func zero(b backend) value {
+	z, _ := b.Parse("0")
+	return z
+}
+
+func abs(b backend, x value) (value, error) {
+	if b.Cmp(x, zero(b)) < 0 {
+		return b.Neg(x)
+	}
+	return x, nil
+}
+
+func minOp(b backend, x, y value) (value, error) {
+	if b.Cmp(y, x) < 0 {
+		return y, nil
+	}
+	return x, nil
+}
+
+func maxOp(b backend, x, y value) (value, error) {
+	if b.Cmp(y, x) > 0 {
+		return y, nil
+	}
+	return x, nil
+}
+
+// gcd is computed by Euclidean algorithm with remainder of backend, so decimals have it too:
+// gcd 1.5 1.0 is 0.5. The result is never negative.
+func gcd(b backend, x, y value) (value, error) {
+	for b.Cmp(y, zero(b)) != 0 {
+		if d, _ := b.Sub(y, y); b.Cmp(d, zero(b)) != 0 {
+			return d, nil // y is infinity or NaN, there is no divisor, so the result is NaN
+		}
+		r, err := b.Mod(x, y)
+		if err != nil {
+			return nil, err
+		}
+		x, y = y, r
+	}
+	return abs(b, x)
+}
+
+// lcm is |x / gcd(x, y) * y|, it's zero if any argument is zero.
+func lcm(b backend, x, y value) (value, error) {
+	if b.Cmp(x, zero(b)) == 0 || b.Cmp(y, zero(b)) == 0 {
+		return zero(b), nil
+	}
+	g, err := gcd(b, x, y)
+	if err != nil {
+		return nil, err
+	}
+	q, err := b.Div(x, g)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.Mul(q, y)
+	if err != nil {
+		return nil, err
+	}
+	return abs(b, r)
+}
+
+// userFunction makes function of def statement. Its body is computed with arguments as local
+// variables, global variables can be used in it too.
+func userFunction(def defStmt) function {
+	return func(ev *evaluator, args []value) (value, error) {
+		if ev.depth >= maxCallDepth {
+			return nil, fmt.Errorf("error in expression %s: %w", def.name, ErrRecursionLimit)
+		}
+
+		locals := make(map[string]value, len(args))
+		for i, param := range def.params {
+			locals[param] = args[i]
+		}
+		saved := ev.locals
+		ev.locals = locals
+		ev.depth++
+		defer func() {
+			ev.locals = saved
+			ev.depth--
+		}()
+
+		return ev.eval(def.body)
+	}
+}
+
+// aritiesText formats arities for error message: "1 or 2".
+func aritiesText(arities []int) string {
+	s := make([]string, len(arities))
+	for i, n := range arities {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, " or ")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		line   string
+		native string
+		big    string
+	}{
+		{"gcd 12 18", "6", "6"},
+		{"gcd -12 18", "6", "6"},
+		{"gcd 0 5", "5", "5"},
+		{"lcm 4 6", "12", "12"},
+		{"lcm -4 6", "12", "12"},
+		{"lcm 0 6", "0", "0"},
+		{"abs -7", "7", "7"},
+		{"abs 2.5", "2.5", "5/2"},
+		{"min 3 -2", "-2", "-2"},
+		{"max 3 -2", "3", "3"},
+		{"max 1.5 2", "2", "2"},
+		{"min 0.1 1e-1", "0.1", "1/10"},
+		{"sqrt 16", "4", "4"},
+		{"sqrt 2.25", "1.5", "3/2"},
+		{"sqrt 0", "0", "0"},
+		{"sqrt 9223372030926249001", "3037000499", "3037000499"},
+		{"abs (sqrt 4 - 10) + max 1 2", "10", "10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := evalLine(nativeBackend{precision: -1}, tt.line)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.native, got)
+			}
+			got, err = evalLine(bigBackend{precision: -1}, tt.line)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.big, got)
+			}
+		})
+	}
+
+	got, err := evalLine(nativeBackend{precision: -1}, "sqrt 2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.4142135623730951", got)
+	got, err = evalLine(nativeBackend{precision: -1}, "gcd 1.5 1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.5", got)
+	got, err = evalLine(nativeBackend{precision: -1}, "gcd 1e0 (1 / 0e0)")
+	assert.NoError(t, err)
+	assert.Equal(t, "NaN", got)
+
+	for _, line := range []string{"sqrt -4", "sqrt -0.5", "abs 1 2", "min 1", "foo 1", "sqrt"} {
+		_, err := evalLine(nativeBackend{precision: -1}, line)
+		assert.Error(t, err, line)
+	}
+	for _, line := range []string{"sqrt 2", "gcd 1.5 1.0"} {
+		_, err := evalLine(bigBackend{precision: -1}, line)
+		assert.Error(t, err, line)
+	}
+	_, err = evalLine(nativeBackend{precision: -1}, "abs -9223372036854775808")
+	assert.ErrorIs(t, err, ErrIntOverflow)
+	_, err = evalLine(nativeBackend{precision: -1}, "lcm 9223372036854775807 2")
+	assert.ErrorIs(t, err, ErrIntOverflow)
+}
+
+func TestUserFunctions(t *testing.T) {
+	script := "def sq x = mul x x\n" +
+		"sq 7\n" +
+		"def hyp a b = sqrt (sq a + sq b)\n" +
+		"hyp 3 4\n" +
+		"def sq x y = x * y\n" +
+		"sq 2 5 + sq 3\n" +
+		"let k = 10\n" +
+		"def scale x = x * k\n" +
+		"scale 4\n" +
+		"let k = 100\n" +
+		"scale 4\n" +
+		"def answer = 42\n" +
+		"answer + 1\n" +
+		"def sq x = x ^ 2\n" +
+		"hyp 6 8\n"
+	got, err := runScript(nativeBackend{precision: -1}, nil, script)
+	assert.NoError(t, err)
+	assert.Equal(t, "49\n5\n19\n40\n400\n43\n10\n", got)
+
+	tests := []struct {
+		script string
+		want   error
+	}{
+		{"def f x = f x\nf 1", ErrRecursionLimit},
+		{"def f x = g x\ndef g x = f x + 1\nf 1", ErrRecursionLimit},
+		{"def f x = x * 2\nf 9223372036854775807", ErrIntOverflow},
+		{"def f x = y\nf 1", ErrUndefinedVariable},
+		{"def f x = x\nf 1\nx", ErrUndefinedVariable},
+	}
+	for _, tt := range tests {
+		_, err := runScript(nativeBackend{precision: -1}, nil, tt.script)
+		assert.ErrorIs(t, err, tt.want, tt.script)
+	}
+
+	for _, script := range []string{"def mul x = x", "let x = 1\ndef x y = y", "def f x = x\nlet f = 1", "def f x = x\nf 1 2"} {
+		_, err := runScript(nativeBackend{precision: -1}, nil, script)
+		assert.Error(t, err, script)
+	}
+	for _, line := range []string{"def = 1", "def f x x = x", "def f 1 = 2", "def f x", "def let = 1", "def f def = 1"} {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+}
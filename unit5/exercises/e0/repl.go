@@ -0,0 +1,102 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// lineReader reads lines of interactive session, it's term.Terminal which gives line editing and history.
+type lineReader interface {
+	ReadLine() (string, error)
+}
+
+// interactive tells if stdin is terminal, then script is typed line by line.
+func interactive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// startREPL switches terminal to raw mode, so term.Terminal can edit lines, and runs session in it.
+func startREPL(ev *evaluator) error {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "> ")
+	if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+		t.SetSize(width, height)
+	}
+	return repl(ev, t, t)
+}
+
+// repl computes every line as soon as it's read. Errors are printed and the session goes on,
+// variables, functions and results are kept between lines. Ctrl-D, Ctrl-C or :quit ends it.
+func repl(ev *evaluator, in lineReader, out io.Writer) error {
+	fmt.Fprintln(out, "Type :help for help, :quit or Ctrl-D to exit.")
+
+	for lineNo := 1; ; lineNo++ {
+		line, err := in.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch cmd := strings.TrimSpace(line); {
+		case cmd == ":help":
+			printHelp(ev, out)
+			continue
+		case cmd == ":quit" || cmd == ":q":
+			return nil
+		case strings.HasPrefix(cmd, ":"):
+			fmt.Fprintf(out, "unknown command %s, type :help for help\n", cmd)
+			continue
+		}
+
+		if err := execLine(ev, Pos{File: "<repl>", Line: lineNo}, line, out); err != nil {
+			fmt.Fprintln(out, render(err))
+		}
+	}
+}
+
+func printHelp(ev *evaluator, out io.Writer) {
+	fmt.Fprint(out, `Every line is computed at once:
+  2 + 3 * 4, mul 2 3      expression, its result is printed
+  let x = mul 3 4         assigns variable
+  def sq x = mul x x      defines function
+  $_, $1                  the last and the first result
+  #on-error divzero=skip  sets error policy
+Commands: :help, :quit
+`)
+
+	keys := slices.SortedFunc(maps.Keys(ev.ops), func(a, b opKey) int {
+		return cmp.Or(strings.Compare(a.name, b.name), cmp.Compare(a.arity, b.arity))
+	})
+
+	var ops, funcs []string
+	for _, key := range keys {
+		name := fmt.Sprintf("%s/%d", key.name, key.arity)
+		if _, ok := builtins[key]; ok {
+			ops = append(ops, name)
+		} else {
+			funcs = append(funcs, name)
+		}
+	}
+	fmt.Fprintf(out, "Operations: %s\n", strings.Join(ops, " "))
+	if len(funcs) > 0 {
+		fmt.Fprintf(out, "Functions: %s\n", strings.Join(funcs, " "))
+	}
+}
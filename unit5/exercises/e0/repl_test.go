@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// typedLines is lineReader of lines typed in advance.
+type typedLines []string
+
+func (l *typedLines) ReadLine() (string, error) {
+	if len(*l) == 0 {
+		return "", io.EOF
+	}
+	line := (*l)[0]
+	*l = (*l)[1:]
+	return line, nil
+}
+
+func TestREPL(t *testing.T) {
+	in := typedLines{
+		"mul 2 3",
+		"1 +",
+		"div 1 0",
+		"let x = $1 * 2",
+		"",
+		"def sq x = x * x",
+		"sq x",
+		":foo",
+		":help",
+		":quit",
+		"mul 5 5",
+	}
+	var out strings.Builder
+	err := repl(newEvaluator(nativeBackend{precision: -1}), &in, &out)
+	assert.NoError(t, err)
+
+	got := out.String()
+	assert.True(t, strings.HasPrefix(got, "Type :help for help, :quit or Ctrl-D to exit.\n6\n"), got)
+	assert.Contains(t, got, "<repl>:2:4: unexpected end of line\n1 +\n   ^\n")
+	assert.Contains(t, got, "<repl>:3:1: error in expression 1 / 0: divide by zero\ndiv 1 0\n^\n")
+	assert.Contains(t, got, "\n144\n")
+	assert.Contains(t, got, "unknown command :foo")
+	assert.Contains(t, got, "Operations: abs/1 add/2 div/2 gcd/2 lcm/2 max/2 min/2 mod/2 mul/2 pow/2 sqrt/1 sub/2\n")
+	assert.Contains(t, got, "Functions: sq/1\n")
+	assert.NotContains(t, got, "25", "lines after :quit must not be computed")
+}
+
+func TestREPLEndOfInput(t *testing.T) {
+	in := typedLines{"#on-error divzero=substitute:eternity", "div 1 0", "$_ + 1"}
+	var out strings.Builder
+	err := repl(newEvaluator(nativeBackend{precision: -1}), &in, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "eternity\n")
+	assert.Contains(t, out.String(), "result is substituted")
+}